@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var CGROUP_FORMER map[string]map[string]uint64 = nil
+var CGROUP_FORMER_TIME time.Time
+
+// Reads "key value" files such as cpu.stat, memory.events or /proc/vmstat
+func readFlatKeyed(path string) map[string]uint64 {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(file), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = value
+	}
+	return values
+}
+
+func readSingleValue(path string) (string, bool) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(file)), true
+}
+
+// Sums "8:0 rbytes=1 wbytes=2 rios=3 wios=4" lines of io.stat over all devices
+func readIOStat(path string) map[string]uint64 {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(file), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, raw, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			value, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				continue
+			}
+			values[key] += value
+		}
+	}
+	return values
+}
+
+func listCgroups() []string {
+	if len(CGROUP_PATHS) > 0 {
+		return CGROUP_PATHS
+	}
+	if CGROUP_DEPTH <= 0 {
+		return []string{}
+	}
+
+	cgroups := []string{}
+	filepath.WalkDir(CGROUP_ROOT, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(CGROUP_ROOT, path)
+		if rel == "." {
+			return nil
+		}
+		if strings.Count(rel, string(filepath.Separator))+1 > CGROUP_DEPTH {
+			return filepath.SkipDir
+		}
+		cgroups = append(cgroups, rel)
+		return nil
+	})
+	return cgroups
+}
+
+func getCgroupStat(name string, elapsed float64, current map[string]map[string]uint64) map[string]interface{} {
+	dir := filepath.Join(CGROUP_ROOT, name)
+
+	counters := map[string]uint64{}
+	for key, value := range readFlatKeyed(filepath.Join(dir, "cpu.stat")) {
+		counters["cpu."+key] = value
+	}
+	for key, value := range readFlatKeyed(filepath.Join(dir, "memory.events")) {
+		counters["memory."+key] = value
+	}
+	for key, value := range readIOStat(filepath.Join(dir, "io.stat")) {
+		counters["io."+key] = value
+	}
+	pressure := readPressureDir(dir, ".pressure", CGROUP_FORMER[name], counters)
+	current[name] = counters
+
+	former, ok := CGROUP_FORMER[name]
+	if !ok {
+		former = counters
+	}
+	delta := func(key string) uint64 {
+		return deltaUint64(counters[key], former[key])
+	}
+
+	cpuPercent := 0.0
+	if elapsed > 0 {
+		cpuPercent = float64(delta("cpu.usage_usec")) / 1e6 / elapsed * 100
+	}
+
+	stat := map[string]interface{}{
+		"cpu": map[string]interface{}{
+			"percent":        fmt.Sprintf("%.2f", cpuPercent),
+			"nr_periods":     delta("cpu.nr_periods"),
+			"nr_throttled":   delta("cpu.nr_throttled"),
+			"throttled_usec": delta("cpu.throttled_usec"),
+		},
+		"memory": map[string]interface{}{
+			"oom":      delta("memory.oom"),
+			"oom_kill": delta("memory.oom_kill"),
+			"high":     delta("memory.high"),
+			"max":      delta("memory.max"),
+		},
+		"io": map[string]interface{}{
+			"rbytes": delta("io.rbytes"),
+			"wbytes": delta("io.wbytes"),
+			"rios":   delta("io.rios"),
+			"wios":   delta("io.wios"),
+		},
+	}
+
+	if value, ok := readSingleValue(filepath.Join(dir, "memory.current")); ok {
+		memoryCurrent, _ := strconv.ParseUint(value, 10, 64)
+		stat["memory"].(map[string]interface{})["current"] = fmt.Sprintf("%.2f", float32(memoryCurrent)/1024/1024)
+	}
+	if value, ok := readSingleValue(filepath.Join(dir, "memory.max")); ok {
+		if memoryMax, err := strconv.ParseUint(value, 10, 64); err == nil {
+			value = fmt.Sprintf("%.2f", float32(memoryMax)/1024/1024)
+		}
+		stat["memory"].(map[string]interface{})["limit"] = value
+	}
+	if len(pressure) > 0 {
+		stat["pressure"] = pressure
+	}
+	if value, ok := readSingleValue(filepath.Join(dir, "pids.current")); ok {
+		stat["pids"], _ = strconv.ParseUint(value, 10, 64)
+	}
+
+	return stat
+}
+
+func getCgroups() string {
+	// Get resource accounting of cgroup v2 groups
+	cgroups := listCgroups()
+	if len(cgroups) == 0 {
+		return "{}"
+	}
+	if _, err := os.Stat(filepath.Join(CGROUP_ROOT, "cgroup.controllers")); err != nil {
+		logMessage(DEBUG, fmt.Sprintf("cgroup v2 is not mounted at %v", CGROUP_ROOT))
+		return "{}"
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(CGROUP_FORMER_TIME).Seconds()
+	current := make(map[string]map[string]uint64, len(cgroups))
+	stats := make(map[string]interface{}, len(cgroups))
+
+	for _, name := range cgroups {
+		if _, err := os.Stat(filepath.Join(CGROUP_ROOT, name)); err != nil {
+			logMessage(DEBUG, fmt.Sprintf("cgroup %v not found", name))
+			continue
+		}
+		stats[name] = getCgroupStat(name, elapsed, current)
+	}
+
+	CGROUP_FORMER = current
+	CGROUP_FORMER_TIME = now
+
+	data, _ := json.Marshal(stats)
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
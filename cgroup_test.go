@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func writeFixture(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setCgroupRoot(t *testing.T, depth int, paths ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	oldRoot, oldDepth, oldPaths, oldFormer := CGROUP_ROOT, CGROUP_DEPTH, CGROUP_PATHS, CGROUP_FORMER
+	CGROUP_ROOT, CGROUP_DEPTH, CGROUP_PATHS, CGROUP_FORMER = root, depth, paths, nil
+	t.Cleanup(func() {
+		CGROUP_ROOT, CGROUP_DEPTH, CGROUP_PATHS, CGROUP_FORMER = oldRoot, oldDepth, oldPaths, oldFormer
+	})
+	return root
+}
+
+func TestReadFlatKeyed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.stat")
+	writeFixture(t, path, "usage_usec 1500\nnr_periods 10\nbroken\nbad value\nthrottled_usec 7\n")
+
+	got := readFlatKeyed(path)
+	want := map[string]uint64{"usage_usec": 1500, "nr_periods": 10, "throttled_usec": 7}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readFlatKeyed() = %v, want %v", got, want)
+	}
+	if got := readFlatKeyed(path + ".missing"); got != nil {
+		t.Errorf("readFlatKeyed(missing) = %v, want nil", got)
+	}
+}
+
+func TestReadIOStat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "io.stat")
+	writeFixture(t, path, "8:0 rbytes=100 wbytes=200 rios=1 wios=2 dbytes=0 dios=0\n"+
+		"8:16 rbytes=50 wbytes=25 rios=3 wios=4\n"+
+		"253:0\n")
+
+	got := readIOStat(path)
+	want := map[string]uint64{"rbytes": 150, "wbytes": 225, "rios": 4, "wios": 6, "dbytes": 0, "dios": 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readIOStat() = %v, want %v", got, want)
+	}
+}
+
+func TestListCgroupsDepth(t *testing.T) {
+	root := setCgroupRoot(t, 0)
+	for _, dir := range []string{"system.slice/nginx.service", "system.slice/cron.service", "user.slice/user-0.slice/session-1.scope"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{0, []string{}},
+		{1, []string{"system.slice", "user.slice"}},
+		{2, []string{"system.slice", "system.slice/cron.service", "system.slice/nginx.service", "user.slice", "user.slice/user-0.slice"}},
+	}
+	for _, test := range tests {
+		CGROUP_DEPTH = test.depth
+		got := listCgroups()
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("listCgroups() at depth %v = %v, want %v", test.depth, got, test.want)
+		}
+	}
+
+	// Explicit paths take precedence over the depth
+	CGROUP_PATHS = []string{"system.slice/nginx.service"}
+	if got := listCgroups(); !reflect.DeepEqual(got, CGROUP_PATHS) {
+		t.Errorf("listCgroups() with CGROUP_PATHS = %v, want %v", got, CGROUP_PATHS)
+	}
+}
+
+func TestGetCgroupStatDelta(t *testing.T) {
+	root := setCgroupRoot(t, 1)
+	dir := filepath.Join(root, "app.slice")
+	writeFixture(t, filepath.Join(dir, "cpu.stat"), "usage_usec 1000000\nnr_periods 5\nnr_throttled 1\nthrottled_usec 100\n")
+	writeFixture(t, filepath.Join(dir, "memory.events"), "low 0\nhigh 0\nmax 1\noom 0\noom_kill 0\n")
+	writeFixture(t, filepath.Join(dir, "io.stat"), "8:0 rbytes=1000 wbytes=2000 rios=10 wios=20\n")
+	writeFixture(t, filepath.Join(dir, "memory.current"), "1048576\n")
+	writeFixture(t, filepath.Join(dir, "memory.max"), "max\n")
+	writeFixture(t, filepath.Join(dir, "pids.current"), "3\n")
+	writeFixture(t, filepath.Join(dir, "cpu.pressure"), "some avg10=1.00 avg60=0.50 avg300=0.10 total=500\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=100\n")
+
+	// The first sample has nothing to compare with, all deltas are zero
+	current := map[string]map[string]uint64{}
+	stat := getCgroupStat("app.slice", 1, current)
+	if got := stat["cpu"].(map[string]interface{})["percent"]; got != "0.00" {
+		t.Errorf("first cpu percent = %v, want 0.00", got)
+	}
+	if got := stat["io"].(map[string]interface{})["rbytes"]; got != uint64(0) {
+		t.Errorf("first io rbytes = %v, want 0", got)
+	}
+	CGROUP_FORMER = current
+
+	writeFixture(t, filepath.Join(dir, "cpu.stat"), "usage_usec 1500000\nnr_periods 9\nnr_throttled 3\nthrottled_usec 400\n")
+	writeFixture(t, filepath.Join(dir, "memory.events"), "low 0\nhigh 0\nmax 2\noom 1\noom_kill 1\n")
+	writeFixture(t, filepath.Join(dir, "io.stat"), "8:0 rbytes=1500 wbytes=2000 rios=15 wios=20\n")
+	writeFixture(t, filepath.Join(dir, "cpu.pressure"), "some avg10=1.00 avg60=0.50 avg300=0.10 total=800\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=100\n")
+
+	current = map[string]map[string]uint64{}
+	stat = getCgroupStat("app.slice", 2, current)
+
+	cpu := stat["cpu"].(map[string]interface{})
+	if cpu["percent"] != "25.00" || cpu["nr_periods"] != uint64(4) || cpu["nr_throttled"] != uint64(2) || cpu["throttled_usec"] != uint64(300) {
+		t.Errorf("cpu = %v", cpu)
+	}
+	memory := stat["memory"].(map[string]interface{})
+	if memory["oom"] != uint64(1) || memory["oom_kill"] != uint64(1) || memory["max"] != uint64(1) {
+		t.Errorf("memory events = %v", memory)
+	}
+	if memory["current"] != "1.00" || memory["limit"] != "max" {
+		t.Errorf("memory current/limit = %v/%v, want 1.00/max", memory["current"], memory["limit"])
+	}
+	io := stat["io"].(map[string]interface{})
+	if io["rbytes"] != uint64(500) || io["wbytes"] != uint64(0) || io["rios"] != uint64(5) {
+		t.Errorf("io = %v", io)
+	}
+	if stat["pids"] != uint64(3) {
+		t.Errorf("pids = %v, want 3", stat["pids"])
+	}
+	some := stat["pressure"].(map[string]interface{})["cpu"].(map[string]interface{})["some"].(map[string]interface{})
+	if some["total"] != uint64(300) {
+		t.Errorf("cpu pressure some total = %v, want 300", some["total"])
+	}
+}
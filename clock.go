@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+func getNTPOffset() (map[string]interface{}, time.Duration, bool) {
+	response, err := ntp.QueryWithOptions(NTP_SERVER, ntp.QueryOptions{
+		Timeout: time.Duration(SOCKET_TIMEOUT) * time.Second,
+	})
+	if err == nil {
+		err = response.Validate()
+	}
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to query NTP server %v: %v", NTP_SERVER, err))
+		return map[string]interface{}{"server": NTP_SERVER, "error": err.Error()}, 0, false
+	}
+
+	return map[string]interface{}{
+		"server":  NTP_SERVER,
+		"offset":  fmt.Sprintf("%.3f", float64(response.ClockOffset)/float64(time.Millisecond)),
+		"rtt":     fmt.Sprintf("%.3f", float64(response.RTT)/float64(time.Millisecond)),
+		"stratum": response.Stratum,
+	}, response.ClockOffset, true
+}
+
+func getClock() string {
+	// Get kernel time sync status and offset to NTP_SERVER
+	clock := map[string]interface{}{}
+
+	kernel, kernelOffset, hasKernel := getKernelClock()
+	if hasKernel {
+		clock["kernel"] = kernel
+	}
+
+	// Offset measured against a server is preferred over the kernel estimate
+	offset, hasOffset := kernelOffset, hasKernel
+	if NTP_SERVER != "" {
+		var ntpOffset time.Duration
+		var ok bool
+		clock["ntp"], ntpOffset, ok = getNTPOffset()
+		if ok {
+			offset, hasOffset = ntpOffset, true
+		}
+	}
+
+	threshold := time.Duration(CLOCK_OFFSET_WARN) * time.Millisecond
+	if hasOffset && threshold > 0 && time.Duration(math.Abs(float64(offset))) > threshold {
+		clock["warning"] = fmt.Sprintf("clock offset %v exceeds %v", offset, threshold)
+		logMessage(ERROR, fmt.Sprintf("Clock offset %v exceeds %v", offset, threshold))
+	}
+
+	data, _ := json.Marshal(clock)
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
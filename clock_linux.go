@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// From <sys/timex.h>, not exported by x/sys/unix
+const (
+	STA_UNSYNC = 0x0040
+	STA_NANO   = 0x2000
+	TIME_ERROR = 5
+)
+
+func getKernelClock() (map[string]interface{}, time.Duration, bool) {
+	// A zero Modes only reads the kernel PLL state
+	var timex unix.Timex
+	state, err := unix.Adjtimex(&timex)
+	if err != nil {
+		logMessage(DEBUG, fmt.Sprintf("Fail to call adjtimex: %v", err))
+		return nil, 0, false
+	}
+
+	offset := time.Duration(timex.Offset) * time.Microsecond
+	if timex.Status&STA_NANO != 0 {
+		offset = time.Duration(timex.Offset)
+	}
+	synced := state != TIME_ERROR && timex.Status&STA_UNSYNC == 0
+
+	return map[string]interface{}{
+		"synced":   synced,
+		"offset":   fmt.Sprintf("%.3f", float64(offset)/float64(time.Millisecond)),
+		"maxerror": fmt.Sprintf("%.3f", float64(timex.Maxerror)/1000),
+		"esterror": fmt.Sprintf("%.3f", float64(timex.Esterror)/1000),
+	}, offset, true
+}
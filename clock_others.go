@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "time"
+
+func getKernelClock() (map[string]interface{}, time.Duration, bool) {
+	return nil, 0, false
+}
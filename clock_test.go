@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func toNTPTimestamp(t time.Time) uint64 {
+	d := t.Sub(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC))
+	seconds := uint64(d / time.Second)
+	fraction := uint64(d%time.Second) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// Answers NTP client requests with a clock running ahead by offset
+func startNTPResponder(t *testing.T, offset time.Duration, stratum byte) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		request := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			now := time.Now().Add(offset)
+			response := make([]byte, 48)
+			response[0] = 4<<3 | 4 // no leap warning, version 4, server mode
+			response[1] = stratum
+			copy(response[12:16], "TEST")
+			binary.BigEndian.PutUint64(response[16:], toNTPTimestamp(now.Add(-time.Minute)))
+			// Origin is the transmit time of the request
+			copy(response[24:32], request[40:48])
+			binary.BigEndian.PutUint64(response[32:], toNTPTimestamp(now))
+			binary.BigEndian.PutUint64(response[40:], toNTPTimestamp(now))
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	oldServer, oldWarn := NTP_SERVER, CLOCK_OFFSET_WARN
+	NTP_SERVER, CLOCK_OFFSET_WARN = conn.LocalAddr().String(), 1000
+	t.Cleanup(func() {
+		NTP_SERVER, CLOCK_OFFSET_WARN = oldServer, oldWarn
+		conn.Close()
+	})
+}
+
+func TestGetNTPOffset(t *testing.T) {
+	startNTPResponder(t, 2*time.Second, 2)
+
+	ntp, offset, ok := getNTPOffset()
+	if !ok {
+		t.Fatalf("getNTPOffset() = %v, want a response", ntp)
+	}
+	if offset < 1900*time.Millisecond || offset > 2100*time.Millisecond {
+		t.Errorf("offset = %v, want about 2s", offset)
+	}
+	if ms, _ := strconv.ParseFloat(ntp["offset"].(string), 64); ms < 1900 || ms > 2100 {
+		t.Errorf("offset = %v, want about 2000", ntp["offset"])
+	}
+	if ntp["stratum"] != uint8(2) {
+		t.Errorf("stratum = %v, want 2", ntp["stratum"])
+	}
+}
+
+func TestGetNTPOffsetInvalid(t *testing.T) {
+	// Stratum 0 is a kiss-of-death packet
+	startNTPResponder(t, 0, 0)
+
+	ntp, _, ok := getNTPOffset()
+	if ok || ntp["error"] == nil {
+		t.Errorf("getNTPOffset() = %v, want an error", ntp)
+	}
+}
+
+func TestGetClockWarning(t *testing.T) {
+	startNTPResponder(t, -3*time.Second, 2)
+
+	var clock map[string]interface{}
+	if err := json.Unmarshal([]byte(getClock()), &clock); err != nil {
+		t.Fatal(err)
+	}
+	if clock["ntp"] == nil || clock["warning"] == nil {
+		t.Errorf("getClock() = %v, want ntp offset and warning", clock)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/shirou/gopsutil/v4/net"
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+type listenSocket struct {
+	Proto   string `json:"proto"`
+	Address string `json:"address"`
+	Port    uint32 `json:"port"`
+	Pid     int32  `json:"pid"`
+	Process string `json:"process"`
+}
+
+var LISTEN_FORMER map[string]listenSocket = nil
+
+func getConnectionProto(conn net.ConnectionStat) string {
+	proto := "tcp"
+	if conn.Type == syscall.SOCK_DGRAM {
+		proto = "udp"
+	}
+	if conn.Family == syscall.AF_INET6 {
+		return proto + "6"
+	}
+	return proto + "4"
+}
+
+// Client sockets get their source port from this range, Linux only exposes it in procfs
+func getEphemeralPortRange() (uint32, uint32) {
+	file, err := os.ReadFile(filepath.Join(PROCFS_PATH, "sys", "net", "ipv4", "ip_local_port_range"))
+	if err == nil {
+		fields := strings.Fields(string(file))
+		if len(fields) == 2 {
+			low, errLow := strconv.ParseUint(fields[0], 10, 16)
+			high, errHigh := strconv.ParseUint(fields[1], 10, 16)
+			if errLow == nil && errHigh == nil && low <= high {
+				return uint32(low), uint32(high)
+			}
+		}
+	}
+	// IANA dynamic ports, used by the BSDs and Windows
+	return 49152, 65535
+}
+
+func getProcessName(pid int32, cache map[int32]string) string {
+	if pid == 0 {
+		return ""
+	}
+	if name, ok := cache[pid]; ok {
+		return name
+	}
+	name := ""
+	if p, err := process.NewProcess(pid); err == nil {
+		name, _ = p.Name()
+	}
+	cache[pid] = name
+	return name
+}
+
+func diffListenSockets(current map[string]listenSocket) ([]listenSocket, []listenSocket) {
+	added := []listenSocket{}
+	removed := []listenSocket{}
+	if LISTEN_FORMER == nil {
+		return added, removed
+	}
+
+	for key, socket := range current {
+		if _, ok := LISTEN_FORMER[key]; !ok {
+			added = append(added, socket)
+			logMessage(INFO, fmt.Sprintf("New listener %v %v:%v (%v)", socket.Proto, socket.Address, socket.Port, socket.Process))
+		}
+	}
+	for key, socket := range LISTEN_FORMER {
+		if _, ok := current[key]; !ok {
+			removed = append(removed, socket)
+			logMessage(INFO, fmt.Sprintf("Listener gone %v %v:%v (%v)", socket.Proto, socket.Address, socket.Port, socket.Process))
+		}
+	}
+	return added, removed
+}
+
+func getConnectionDetail() string {
+	// Get connection count per TCP state, protocol and listening port
+	conns, err := net.Connections("inet")
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to list connections: %v", err))
+		return "{}"
+	}
+
+	data, _ := json.Marshal(summarizeConnections(conns))
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
+
+func summarizeConnections(conns []net.ConnectionStat) map[string]interface{} {
+	states := map[string]map[string]int{}
+	protos := map[string]int{}
+	ports := map[string]int{}
+	listeners := map[string]listenSocket{}
+	names := map[int32]string{}
+	ephemeralLow, ephemeralHigh := getEphemeralPortRange()
+
+	for _, conn := range conns {
+		proto := getConnectionProto(conn)
+		protos[proto]++
+		if conn.Type == syscall.SOCK_STREAM {
+			if states[proto] == nil {
+				states[proto] = map[string]int{}
+			}
+			states[proto][conn.Status]++
+		}
+
+		// TCP sockets in LISTEN and unconnected UDP sockets accept traffic. UDP
+		// clients such as resolvers sit unconnected on an ephemeral port, skip those.
+		udpListener := conn.Type == syscall.SOCK_DGRAM && conn.Raddr.Port == 0 &&
+			(conn.Laddr.Port < ephemeralLow || conn.Laddr.Port > ephemeralHigh)
+		if conn.Status == "LISTEN" || udpListener {
+			socket := listenSocket{
+				Proto:   proto,
+				Address: maskIP(conn.Laddr.IP),
+				Port:    conn.Laddr.Port,
+				Pid:     conn.Pid,
+				Process: getProcessName(conn.Pid, names),
+			}
+			listeners[fmt.Sprintf("%v %v:%v", proto, conn.Laddr.IP, socket.Port)] = socket
+		}
+	}
+
+	listenPorts := map[uint32]bool{}
+	for _, socket := range listeners {
+		if socket.Proto[:3] == "tcp" {
+			listenPorts[socket.Port] = true
+		}
+	}
+	for _, conn := range conns {
+		if conn.Type == syscall.SOCK_STREAM && conn.Status != "LISTEN" && listenPorts[conn.Laddr.Port] {
+			ports[strconv.FormatUint(uint64(conn.Laddr.Port), 10)]++
+		}
+	}
+
+	added, removed := diffListenSockets(listeners)
+	LISTEN_FORMER = listeners
+
+	inventory := make([]listenSocket, 0, len(listeners))
+	for _, socket := range listeners {
+		inventory = append(inventory, socket)
+	}
+	sort.Slice(inventory, func(i, j int) bool {
+		if inventory[i].Port != inventory[j].Port {
+			return inventory[i].Port < inventory[j].Port
+		}
+		return inventory[i].Proto < inventory[j].Proto
+	})
+
+	return map[string]interface{}{
+		"state":  states,
+		"proto":  protos,
+		"port":   ports,
+		"listen": inventory,
+		"change": map[string]interface{}{
+			"added":   added,
+			"removed": removed,
+		},
+	}
+}
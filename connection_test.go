@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/shirou/gopsutil/v4/net"
+)
+
+func setProcfsPath(t *testing.T) string {
+	t.Helper()
+	oldPath, oldFormer := PROCFS_PATH, LISTEN_FORMER
+	PROCFS_PATH, LISTEN_FORMER = t.TempDir(), nil
+	t.Cleanup(func() { PROCFS_PATH, LISTEN_FORMER = oldPath, oldFormer })
+	return PROCFS_PATH
+}
+
+func TestGetEphemeralPortRange(t *testing.T) {
+	root := setProcfsPath(t)
+	if low, high := getEphemeralPortRange(); low != 49152 || high != 65535 {
+		t.Errorf("getEphemeralPortRange() = %v-%v, want 49152-65535 without procfs", low, high)
+	}
+
+	writeFixture(t, filepath.Join(root, "sys", "net", "ipv4", "ip_local_port_range"), "32768\t60999\n")
+	if low, high := getEphemeralPortRange(); low != 32768 || high != 60999 {
+		t.Errorf("getEphemeralPortRange() = %v-%v, want 32768-60999", low, high)
+	}
+}
+
+func TestSummarizeConnections(t *testing.T) {
+	root := setProcfsPath(t)
+	writeFixture(t, filepath.Join(root, "sys", "net", "ipv4", "ip_local_port_range"), "32768 60999\n")
+	oldPrivacy := IP_PRIVACY
+	IP_PRIVACY = "none"
+	t.Cleanup(func() { IP_PRIVACY = oldPrivacy })
+
+	conn := func(family, kind uint32, status, laddr string, lport uint32, raddr string, rport uint32) net.ConnectionStat {
+		return net.ConnectionStat{
+			Family: family, Type: kind, Status: status,
+			Laddr: net.Addr{IP: laddr, Port: lport}, Raddr: net.Addr{IP: raddr, Port: rport},
+		}
+	}
+	conns := []net.ConnectionStat{
+		conn(syscall.AF_INET, syscall.SOCK_STREAM, "LISTEN", "0.0.0.0", 22, "0.0.0.0", 0),
+		conn(syscall.AF_INET, syscall.SOCK_STREAM, "ESTABLISHED", "192.0.2.1", 22, "198.51.100.1", 50000),
+		conn(syscall.AF_INET6, syscall.SOCK_STREAM, "ESTABLISHED", "2001:db8::1", 22, "2001:db8::2", 50001),
+		conn(syscall.AF_INET6, syscall.SOCK_STREAM, "TIME_WAIT", "2001:db8::1", 40000, "2001:db8::3", 443),
+		// DNS server, a resolver client on an ephemeral port, and a connected UDP socket
+		conn(syscall.AF_INET, syscall.SOCK_DGRAM, "NONE", "0.0.0.0", 53, "", 0),
+		conn(syscall.AF_INET, syscall.SOCK_DGRAM, "NONE", "0.0.0.0", 45000, "", 0),
+		conn(syscall.AF_INET6, syscall.SOCK_DGRAM, "NONE", "2001:db8::1", 123, "2001:db8::4", 123),
+	}
+
+	summary := summarizeConnections(conns)
+
+	wantStates := map[string]map[string]int{
+		"tcp4": {"LISTEN": 1, "ESTABLISHED": 1},
+		"tcp6": {"ESTABLISHED": 1, "TIME_WAIT": 1},
+	}
+	if !reflect.DeepEqual(summary["state"], wantStates) {
+		t.Errorf("state = %v, want %v", summary["state"], wantStates)
+	}
+	wantProtos := map[string]int{"tcp4": 2, "tcp6": 2, "udp4": 2, "udp6": 1}
+	if !reflect.DeepEqual(summary["proto"], wantProtos) {
+		t.Errorf("proto = %v, want %v", summary["proto"], wantProtos)
+	}
+	if wantPorts := map[string]int{"22": 2}; !reflect.DeepEqual(summary["port"], wantPorts) {
+		t.Errorf("port = %v, want %v", summary["port"], wantPorts)
+	}
+
+	wantListen := []listenSocket{
+		{Proto: "tcp4", Address: "0.0.0.0", Port: 22},
+		{Proto: "udp4", Address: "0.0.0.0", Port: 53},
+	}
+	if !reflect.DeepEqual(summary["listen"], wantListen) {
+		t.Errorf("listen = %v, want %v", summary["listen"], wantListen)
+	}
+}
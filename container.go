@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type containerSummary struct {
+	Id     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+}
+
+type containerInspect struct {
+	RestartCount int `json:"RestartCount"`
+	State        struct {
+		Health *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+}
+
+type containerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+		SystemUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs  uint32 `json:"online_cpus"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+	BlkioStats struct {
+		IoServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+}
+
+type containerCounters struct {
+	cpu    uint64
+	system uint64
+	rx     uint64
+	tx     uint64
+	read   uint64
+	write  uint64
+}
+
+var CONTAINER_FORMER map[string]containerCounters = nil
+
+func getContainerClient() *http.Client {
+	// Talk HTTP over the engine unix socket, the host part of the URL is ignored
+	return &http.Client{
+		Timeout: time.Duration(SOCKET_TIMEOUT) * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", CONTAINER_SOCKET)
+			},
+		},
+	}
+}
+
+func getContainerAPI(client *http.Client, path string, v interface{}) error {
+	resp, err := client.Get("http://engine" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Label filters are "key" or "key=value"
+func matchLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue := strings.Cut(filter, "=")
+		label, ok := labels[key]
+		if ok && (!hasValue || label == value) {
+			return true
+		}
+	}
+	return false
+}
+
+func deltaUint64(current, former uint64) uint64 {
+	if current > former {
+		return current - former
+	}
+	return 0
+}
+
+func getContainers() string {
+	// Get per container stats from the Docker/Podman engine API
+	if CONTAINER_SOCKET == "" {
+		return "{}"
+	}
+
+	client := getContainerClient()
+	defer client.CloseIdleConnections()
+
+	var summaries []containerSummary
+	if err := getContainerAPI(client, "/containers/json?all=true", &summaries); err != nil {
+		logMessage(DEBUG, fmt.Sprintf("Fail to list containers: %v", err))
+		return "{}"
+	}
+
+	current := make(map[string]containerCounters, len(summaries))
+	containers := make(map[string]interface{}, len(summaries))
+
+	for _, summary := range summaries {
+		if len(CONTAINER_INCLUDE) > 0 && !matchLabels(summary.Labels, CONTAINER_INCLUDE) {
+			continue
+		}
+		if matchLabels(summary.Labels, CONTAINER_EXCLUDE) {
+			continue
+		}
+
+		name := summary.Id[:min(12, len(summary.Id))]
+		if len(summary.Names) > 0 {
+			name = strings.TrimPrefix(summary.Names[0], "/")
+		}
+
+		container := map[string]interface{}{
+			"id":     summary.Id[:min(12, len(summary.Id))],
+			"image":  summary.Image,
+			"state":  summary.State,
+			"status": summary.Status,
+			"health": "none",
+		}
+
+		var inspect containerInspect
+		if err := getContainerAPI(client, "/containers/"+summary.Id+"/json", &inspect); err == nil {
+			container["restarts"] = inspect.RestartCount
+			if inspect.State.Health != nil {
+				container["health"] = inspect.State.Health.Status
+			}
+		} else {
+			logMessage(ERROR, fmt.Sprintf("Fail to inspect container %v: %v", name, err))
+		}
+
+		if summary.State != "running" {
+			containers[name] = container
+			continue
+		}
+
+		var stats containerStats
+		if err := getContainerAPI(client, "/containers/"+summary.Id+"/stats?stream=false&one-shot=true", &stats); err != nil {
+			logMessage(ERROR, fmt.Sprintf("Fail to get stats of container %v: %v", name, err))
+			containers[name] = container
+			continue
+		}
+
+		counters := containerCounters{
+			cpu:    stats.CPUStats.CPUUsage.TotalUsage,
+			system: stats.CPUStats.SystemUsage,
+		}
+		for _, network := range stats.Networks {
+			counters.rx += network.RxBytes
+			counters.tx += network.TxBytes
+		}
+		for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+			switch strings.ToLower(entry.Op) {
+			case "read":
+				counters.read += entry.Value
+			case "write":
+				counters.write += entry.Value
+			}
+		}
+		current[summary.Id] = counters
+
+		former, ok := CONTAINER_FORMER[summary.Id]
+		if !ok {
+			former = counters
+		}
+
+		cpuPercent := 0.0
+		if systemDelta := deltaUint64(counters.system, former.system); systemDelta > 0 {
+			onlineCPUs := float64(max(stats.CPUStats.OnlineCPUs, 1))
+			cpuPercent = float64(deltaUint64(counters.cpu, former.cpu)) / float64(systemDelta) * onlineCPUs * 100
+		}
+
+		// Same as docker stats: page cache is not counted as used memory
+		memory := stats.MemoryStats.Usage
+		if cache, ok := stats.MemoryStats.Stats["inactive_file"]; ok && cache < memory {
+			memory -= cache
+		} else if cache, ok := stats.MemoryStats.Stats["total_inactive_file"]; ok && cache < memory {
+			memory -= cache
+		}
+
+		container["cpu"] = fmt.Sprintf("%.2f", cpuPercent)
+		container["memory"] = fmt.Sprintf("%.2f", float32(memory)/1024/1024)
+		container["memory_limit"] = fmt.Sprintf("%.2f", float32(stats.MemoryStats.Limit)/1024/1024)
+		container["network"] = map[string]uint64{
+			"RX": deltaUint64(counters.rx, former.rx),
+			"TX": deltaUint64(counters.tx, former.tx),
+		}
+		container["io"] = map[string]uint64{
+			"read":  deltaUint64(counters.read, former.read),
+			"write": deltaUint64(counters.write, former.write),
+		}
+		containers[name] = container
+	}
+
+	CONTAINER_FORMER = current
+
+	data, _ := json.Marshal(containers)
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Serves the engine endpoints getContainers uses, stats grow with every call
+func startFakeEngine(t *testing.T, include, exclude []string) {
+	t.Helper()
+	// Unix socket paths are short, keep it out of the nested test temp dir
+	dir, err := os.MkdirTemp("", "engine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "engine.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string]uint64{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"Id": "aaaaaaaaaaaaaaaa", "Names": []string{"/web"}, "Image": "nginx", "State": "running", "Labels": map[string]string{"app": "web"}},
+			{"Id": "bbbbbbbbbbbbbbbb", "Names": []string{"/api"}, "Image": "api", "State": "running", "Labels": map[string]string{"app": "api"}},
+			{"Id": "cccccccccccccccc", "Names": []string{"/db"}, "Image": "postgres", "State": "running", "Labels": map[string]string{"app": "db", "monitor": "off"}},
+			{"Id": "dddddddddddddddd", "Names": []string{"/old"}, "Image": "busybox", "State": "exited", "Labels": map[string]string{"app": "old"}},
+			{"Id": "eeeeeeeeeeeeeeee", "Names": []string{"/tmp"}, "Image": "busybox", "State": "running"},
+		})
+	})
+	mux.HandleFunc("/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"RestartCount":1,"State":{"Health":{"Status":"healthy"}}}`)
+	})
+	mux.HandleFunc("/containers/{id}/stats", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		calls[id]++
+		n := calls[id]
+		// web reports cgroup v2 memory stats, api the cgroup v1 ones
+		memory := map[string]uint64{"inactive_file": 40 * 1024 * 1024}
+		if id == "bbbbbbbbbbbbbbbb" {
+			memory = map[string]uint64{"total_inactive_file": 10 * 1024 * 1024}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"cpu_stats": map[string]interface{}{
+				"cpu_usage":        map[string]uint64{"total_usage": n * 1e9},
+				"system_cpu_usage": n * 10e9,
+				"online_cpus":      2,
+			},
+			"memory_stats": map[string]interface{}{
+				"usage": 100 * 1024 * 1024,
+				"limit": 512 * 1024 * 1024,
+				"stats": memory,
+			},
+			"networks": map[string]interface{}{
+				"eth0": map[string]uint64{"rx_bytes": n * 100, "tx_bytes": n * 200},
+				"eth1": map[string]uint64{"rx_bytes": n * 50, "tx_bytes": 0},
+			},
+			"blkio_stats": map[string]interface{}{
+				"io_service_bytes_recursive": []map[string]interface{}{
+					{"op": "Read", "value": n * 1000},
+					{"op": "Write", "value": n * 2000},
+					{"op": "Total", "value": n * 3000},
+				},
+			},
+		})
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = listener
+	server.Start()
+
+	oldSocket, oldInclude, oldExclude, oldFormer := CONTAINER_SOCKET, CONTAINER_INCLUDE, CONTAINER_EXCLUDE, CONTAINER_FORMER
+	CONTAINER_SOCKET, CONTAINER_INCLUDE, CONTAINER_EXCLUDE, CONTAINER_FORMER = socket, include, exclude, nil
+	t.Cleanup(func() {
+		CONTAINER_SOCKET, CONTAINER_INCLUDE, CONTAINER_EXCLUDE, CONTAINER_FORMER = oldSocket, oldInclude, oldExclude, oldFormer
+		server.Close()
+		os.RemoveAll(dir)
+	})
+}
+
+func getContainersMap(t *testing.T) map[string]map[string]interface{} {
+	t.Helper()
+	var containers map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(getContainers()), &containers); err != nil {
+		t.Fatal(err)
+	}
+	return containers
+}
+
+func TestMatchLabels(t *testing.T) {
+	labels := map[string]string{"app": "web", "tier": "front"}
+	tests := []struct {
+		filters []string
+		want    bool
+	}{
+		{nil, false},
+		{[]string{"app"}, true},
+		{[]string{"app=web"}, true},
+		{[]string{"app=db"}, false},
+		{[]string{"app=db", "tier=front"}, true},
+		{[]string{"missing"}, false},
+	}
+	for _, test := range tests {
+		if got := matchLabels(labels, test.filters); got != test.want {
+			t.Errorf("matchLabels(%v) = %v, want %v", test.filters, got, test.want)
+		}
+	}
+}
+
+func TestGetContainersFilters(t *testing.T) {
+	startFakeEngine(t, []string{"app"}, []string{"monitor=off"})
+
+	containers := getContainersMap(t)
+	names := []string{}
+	for name := range containers {
+		names = append(names, name)
+	}
+	if len(containers) != 3 || containers["web"] == nil || containers["api"] == nil || containers["old"] == nil {
+		t.Fatalf("containers = %v, want api, old and web", names)
+	}
+
+	// Stopped containers are listed without stats
+	if _, ok := containers["old"]["cpu"]; ok {
+		t.Errorf("old = %v, want no stats", containers["old"])
+	}
+	if containers["web"]["health"] != "healthy" || containers["web"]["restarts"] != 1.0 {
+		t.Errorf("web = %v, want healthy with 1 restart", containers["web"])
+	}
+}
+
+func TestGetContainersDelta(t *testing.T) {
+	startFakeEngine(t, nil, nil)
+
+	first := getContainersMap(t)["web"]
+	if first["cpu"] != "0.00" {
+		t.Errorf("first cpu = %v, want 0.00", first["cpu"])
+	}
+	zero := map[string]interface{}{"RX": 0.0, "TX": 0.0}
+	if !reflect.DeepEqual(first["network"], zero) {
+		t.Errorf("first network = %v, want %v", first["network"], zero)
+	}
+
+	containers := getContainersMap(t)
+	web := containers["web"]
+	// 1s of 10s system time on 2 CPUs
+	if web["cpu"] != "20.00" {
+		t.Errorf("cpu = %v, want 20.00", web["cpu"])
+	}
+	if want := map[string]interface{}{"RX": 150.0, "TX": 200.0}; !reflect.DeepEqual(web["network"], want) {
+		t.Errorf("network = %v, want %v", web["network"], want)
+	}
+	if want := map[string]interface{}{"read": 1000.0, "write": 2000.0}; !reflect.DeepEqual(web["io"], want) {
+		t.Errorf("io = %v, want %v", web["io"], want)
+	}
+
+	// Page cache is not counted as used memory
+	if web["memory"] != "60.00" || web["memory_limit"] != "512.00" {
+		t.Errorf("web memory = %v of %v, want 60.00 of 512.00", web["memory"], web["memory_limit"])
+	}
+	if api := containers["api"]; api["memory"] != "90.00" {
+		t.Errorf("api memory = %v, want 90.00", api["memory"])
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// A geoProvider returns country_name, country_code and optionally asn, org
+// and city of this host, or an error when it has no answer.
+type geoProvider interface {
+	Name() string
+	Lookup() (map[string]string, error)
+}
+
+type mmdbProvider struct {
+	db    string
+	asnDB string
+}
+
+// Fields maps the COUNTRY keys to dotted paths in the JSON response
+type httpProvider struct {
+	url    string
+	fields map[string]string
+}
+
+type geoOverride struct {
+	match string
+	name  string
+	code  string
+}
+
+type geoCache struct {
+	ip      string
+	country map[string]string
+	expire  time.Time
+}
+
+var GEOIP_CACHE geoCache
+
+var GEOIP_FIELDS = map[string]map[string]string{
+	"ipwhois.app": {
+		"country_name": "country",
+		"country_code": "country_code",
+		"asn":          "asn",
+		"org":          "org",
+		"city":         "city",
+	},
+	"reallyfreegeoip.org": {
+		"country_name": "country_name",
+		"country_code": "country_code",
+		"city":         "city",
+	},
+	"ip-api.com": {
+		"country_name": "country",
+		"country_code": "countryCode",
+		"asn":          "as",
+		"org":          "org",
+		"city":         "city",
+	},
+	// ipinfo.io only returns the ISO code, which then doubles as the name
+	"ipinfo.io": {
+		"country_code": "country",
+		"org":          "org",
+		"city":         "city",
+	},
+}
+
+// Subset of the GeoIP2/GeoLite2 and DB-IP Lite Country, City and ASN schemas
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"registered_country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN          uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+func lookupMMDB(path string, ip net.IP, record *mmdbRecord) error {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return reader.Lookup(ip, record)
+}
+
+func (p *mmdbProvider) Name() string {
+	return p.db
+}
+
+func (p *mmdbProvider) Lookup() (map[string]string, error) {
+	ip := net.ParseIP(IPV4)
+	if ip == nil {
+		ip = net.ParseIP(IPV6)
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("no IP address to look up")
+	}
+
+	var record mmdbRecord
+	if err := lookupMMDB(p.db, ip, &record); err != nil {
+		return nil, err
+	}
+	if p.asnDB != "" {
+		if err := lookupMMDB(p.asnDB, ip, &record); err != nil {
+			logMessage(ERROR, fmt.Sprintf("Fail to read %v: %v", p.asnDB, err))
+		}
+	}
+
+	if record.Country.ISOCode == "" {
+		record.Country = record.RegisteredCountry
+	}
+	if record.Country.ISOCode == "" {
+		return nil, fmt.Errorf("%v not found", maskIP(ip.String()))
+	}
+
+	name := record.Country.Names["en"]
+	if name == "" {
+		name = record.Country.ISOCode
+	}
+
+	country := map[string]string{
+		"country_name": name,
+		"country_code": record.Country.ISOCode,
+		"city":         record.City.Names["en"],
+		"org":          record.Organization,
+	}
+	if record.ASN != 0 {
+		country["asn"] = fmt.Sprintf("AS%d", record.ASN)
+	}
+	return country, nil
+}
+
+func (p *httpProvider) Name() string {
+	return p.url
+}
+
+func getJSONPath(data interface{}, path string) string {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := data.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		data = object[key]
+	}
+	if data == nil {
+		return ""
+	}
+	if _, ok := data.(map[string]interface{}); ok {
+		return ""
+	}
+	return fmt.Sprint(data)
+}
+
+func (p *httpProvider) Lookup() (map[string]string, error) {
+	data, err := getRequest(p.url, map[string]string{"User-Agent": USER_AGENT})
+	if err != nil {
+		return nil, err
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &response); err != nil {
+		return nil, fmt.Errorf("fail to parse response: %v", err)
+	}
+
+	country := map[string]string{}
+	for field, path := range p.fields {
+		country[field] = getJSONPath(response, path)
+	}
+	if country["country_code"] == "" {
+		return nil, fmt.Errorf("no country in response")
+	}
+	if country["country_name"] == "" {
+		country["country_name"] = country["country_code"]
+	}
+	if _, err := strconv.ParseUint(country["asn"], 10, 32); err == nil {
+		country["asn"] = "AS" + country["asn"]
+	}
+	return country, nil
+}
+
+// GEOIP_API format: "url;url|country_name=country.name,country_code=country.code"
+// Known hosts in GEOIP_FIELDS need no field mapping.
+func parseGeoProviders(providers []string, apis, db, asnDB string) []geoProvider {
+	geoProviders := []geoProvider{}
+
+	for _, provider := range providers {
+		switch provider {
+		case "mmdb":
+			if db == "" {
+				logMessage(ERROR, "GEOIP_DB is not set")
+				continue
+			}
+			geoProviders = append(geoProviders, &mmdbProvider{db: db, asnDB: asnDB})
+		case "online":
+			for _, entry := range strings.Split(apis, ";") {
+				entry = strings.TrimSpace(entry)
+				if entry == "" {
+					continue
+				}
+				api, mapping, _ := strings.Cut(entry, "|")
+
+				fields := map[string]string{}
+				if u, err := url.Parse(api); err == nil {
+					for field, path := range GEOIP_FIELDS[u.Hostname()] {
+						fields[field] = path
+					}
+				}
+				for _, pair := range strings.Split(mapping, ",") {
+					if field, path, ok := strings.Cut(pair, "="); ok {
+						fields[strings.TrimSpace(field)] = strings.TrimSpace(path)
+					}
+				}
+				if fields["country_code"] == "" {
+					logMessage(ERROR, fmt.Sprintf("No country_code mapping for %v", api))
+					continue
+				}
+				geoProviders = append(geoProviders, &httpProvider{url: api, fields: fields})
+			}
+		default:
+			logMessage(ERROR, fmt.Sprintf("Unknown GeoIP provider: %v", provider))
+		}
+	}
+
+	return geoProviders
+}
+
+// GEOIP_OVERRIDE format: "match={name} suffix|code;match=..."
+// The first rule whose match is contained in the country name applies.
+func parseGeoOverrides(config string) []geoOverride {
+	overrides := []geoOverride{}
+	for _, entry := range strings.Split(config, ";") {
+		match, rule, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(match) == "" {
+			continue
+		}
+		name, code, _ := strings.Cut(rule, "|")
+		overrides = append(overrides, geoOverride{
+			match: strings.TrimSpace(match),
+			name:  name,
+			code:  strings.TrimSpace(code),
+		})
+	}
+	return overrides
+}
+
+func applyGeoOverrides(country map[string]string) {
+	for _, override := range GEOIP_OVERRIDES {
+		if !strings.Contains(country["country_name"], override.match) {
+			continue
+		}
+		if override.name != "" {
+			country["country_name"] = strings.ReplaceAll(override.name, "{name}", country["country_name"])
+		}
+		if override.code != "" {
+			country["country_code"] = override.code
+		}
+		return
+	}
+}
+
+func lookupCountry() map[string]string {
+	ip := IPV4 + "," + IPV6
+	if GEOIP_CACHE.country != nil && GEOIP_CACHE.ip == ip && time.Now().Before(GEOIP_CACHE.expire) {
+		logMessage(DEBUG, "Using cached country")
+		return GEOIP_CACHE.country
+	}
+
+	for _, provider := range GEOIP_PROVIDERS {
+		logMessage(INFO, fmt.Sprintf("Fetching country from %v", provider.Name()))
+		country, err := provider.Lookup()
+		if err != nil {
+			logMessage(ERROR, fmt.Sprintf("Fail to fetch country from %v: %v", provider.Name(), err))
+			continue
+		}
+
+		applyGeoOverrides(country)
+		GEOIP_CACHE = geoCache{
+			ip:      ip,
+			country: country,
+			expire:  time.Now().Add(time.Duration(GEOIP_CACHE_TTL) * time.Second),
+		}
+		return country
+	}
+
+	logMessage(ERROR, "Fail to fetch country")
+	return nil
+}
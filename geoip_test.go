@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setLookupIP(t *testing.T, ipv4, ipv6 string) {
+	t.Helper()
+	oldIPV4, oldIPV6 := IPV4, IPV6
+	IPV4, IPV6 = ipv4, ipv6
+	t.Cleanup(func() { IPV4, IPV6 = oldIPV4, oldIPV6 })
+}
+
+func TestMMDBProviderLookup(t *testing.T) {
+	setLookupIP(t, "192.0.2.10", "None")
+
+	provider := &mmdbProvider{db: filepath.Join("testdata", "country.mmdb")}
+	got, err := provider.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"country_name": "Hong Kong",
+		"country_code": "HK",
+		"city":         "Central",
+		"org":          "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup() = %v, want %v", got, want)
+	}
+}
+
+func TestMMDBProviderRegisteredCountry(t *testing.T) {
+	setLookupIP(t, "192.0.2.10", "None")
+
+	provider := &mmdbProvider{db: filepath.Join("testdata", "registered.mmdb")}
+	got, err := provider.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["country_code"] != "JP" || got["country_name"] != "Japan" {
+		t.Errorf("Lookup() = %v, want the registered country JP/Japan", got)
+	}
+}
+
+func TestMMDBProviderASN(t *testing.T) {
+	setLookupIP(t, "192.0.2.10", "None")
+
+	provider := &mmdbProvider{
+		db:    filepath.Join("testdata", "country.mmdb"),
+		asnDB: filepath.Join("testdata", "asn.mmdb"),
+	}
+	got, err := provider.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["country_code"] != "HK" || got["asn"] != "AS64500" || got["org"] != "Example Net" {
+		t.Errorf("Lookup() = %v, want HK with AS64500/Example Net", got)
+	}
+}
+
+func TestMMDBProviderNotFound(t *testing.T) {
+	setLookupIP(t, "198.51.100.1", "None")
+
+	provider := &mmdbProvider{db: filepath.Join("testdata", "country.mmdb")}
+	if got, err := provider.Lookup(); err == nil {
+		t.Errorf("Lookup() = %v, want an error for an address outside the database", got)
+	}
+}
+
+func TestGetJSONPath(t *testing.T) {
+	data := map[string]interface{}{
+		"country": map[string]interface{}{"name": "Japan", "iso": "JP"},
+		"asn":     map[string]interface{}{"number": 64500.0},
+		"city":    "Tokyo",
+		"empty":   nil,
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"city", "Tokyo"},
+		{"country.iso", "JP"},
+		{"asn.number", "64500"},
+		// Objects, nulls and missing keys have no value
+		{"country", ""},
+		{"empty", ""},
+		{"region", ""},
+		{"city.name", ""},
+		{"country.iso.code", ""},
+	}
+	for _, test := range tests {
+		if got := getJSONPath(data, test.path); got != test.want {
+			t.Errorf("getJSONPath(%v) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestParseGeoProviders(t *testing.T) {
+	providers := parseGeoProviders(
+		[]string{"mmdb", "online", "other"},
+		" https://ipinfo.io/json ; ;https://example.com/geo|country_name=country.name, country_code=country.iso;https://example.com/none",
+		"", "",
+	)
+	// mmdb without GEOIP_DB, the unmapped API and the unknown provider are skipped
+	if len(providers) != 2 {
+		t.Fatalf("parseGeoProviders() = %v, want 2 providers", providers)
+	}
+
+	want := []*httpProvider{
+		{url: "https://ipinfo.io/json", fields: GEOIP_FIELDS["ipinfo.io"]},
+		{url: "https://example.com/geo", fields: map[string]string{"country_name": "country.name", "country_code": "country.iso"}},
+	}
+	for i, provider := range providers {
+		if !reflect.DeepEqual(provider, want[i]) {
+			t.Errorf("provider %v = %v, want %v", i, provider, want[i])
+		}
+	}
+
+	// A mapping adds to the fields of a known host
+	providers = parseGeoProviders([]string{"mmdb", "online"}, "https://ipinfo.io/json|asn=asn.id", "country.mmdb", "asn.mmdb")
+	if mmdb, ok := providers[0].(*mmdbProvider); !ok || mmdb.db != "country.mmdb" || mmdb.asnDB != "asn.mmdb" {
+		t.Errorf("provider 0 = %v, want country.mmdb with asn.mmdb", providers[0])
+	}
+	if fields := providers[1].(*httpProvider).fields; fields["asn"] != "asn.id" || fields["country_code"] != "country" {
+		t.Errorf("fields = %v, want ipinfo.io with asn.id", fields)
+	}
+}
+
+func TestHTTPProviderLookup(t *testing.T) {
+	responses := map[string]string{
+		"/nested": `{"country":{"name":"Japan","iso":"JP"},"asn":{"number":64500,"org":"Example Net"},"city":"Tokyo"}`,
+		"/code":   `{"country":{"iso":"HK"},"asn":{"number":"AS64501"}}`,
+		"/none":   `{"country":{"name":"Japan"}}`,
+		"/broken": `not json`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, responses[r.URL.Path])
+	}))
+	defer server.Close()
+
+	mapping := "|country_name=country.name,country_code=country.iso,asn=asn.number,org=asn.org,city=city"
+	providers := parseGeoProviders([]string{"online"}, server.URL+"/nested"+mapping+";"+server.URL+"/code"+mapping, "", "")
+	if len(providers) != 2 {
+		t.Fatalf("parseGeoProviders() = %v, want 2 providers", providers)
+	}
+
+	got, err := providers[0].Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"country_name": "Japan", "country_code": "JP", "asn": "AS64500", "org": "Example Net", "city": "Tokyo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup() = %v, want %v", got, want)
+	}
+
+	// Without a name the code is used, an ASN already prefixed is kept
+	got, err = providers[1].Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{"country_name": "HK", "country_code": "HK", "asn": "AS64501", "org": "", "city": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup() = %v, want %v", got, want)
+	}
+
+	for _, path := range []string{"/none", "/broken"} {
+		provider := &httpProvider{url: server.URL + path, fields: map[string]string{"country_code": "country.iso"}}
+		if got, err := provider.Lookup(); err == nil {
+			t.Errorf("Lookup(%v) = %v, want an error", path, got)
+		}
+	}
+}
+
+func TestParseGeoOverrides(t *testing.T) {
+	got := parseGeoOverrides("Hong Kong={name}, SAR; Taiwan ={name} Province| CN ;broken; =x;Macau=")
+	want := []geoOverride{
+		{match: "Hong Kong", name: "{name}, SAR"},
+		{match: "Taiwan", name: "{name} Province", code: "CN"},
+		{match: "Macau"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGeoOverrides() = %v, want %v", got, want)
+	}
+	if got := parseGeoOverrides(""); len(got) != 0 {
+		t.Errorf("parseGeoOverrides() = %v, want none", got)
+	}
+}
+
+func TestApplyGeoOverrides(t *testing.T) {
+	oldOverrides := GEOIP_OVERRIDES
+	GEOIP_OVERRIDES = parseGeoOverrides("Hong Kong={name}, SAR;Macau={name}, SAR;Taiwan={name} Province|CN")
+	t.Cleanup(func() { GEOIP_OVERRIDES = oldOverrides })
+
+	tests := []struct {
+		name, code         string
+		wantName, wantCode string
+	}{
+		{"Hong Kong", "HK", "Hong Kong, SAR", "HK"},
+		{"Macau", "MO", "Macau, SAR", "MO"},
+		{"Taiwan", "TW", "Taiwan Province", "CN"},
+		{"Japan", "JP", "Japan", "JP"},
+		// Names from an ISO code only provider do not match
+		{"TW", "TW", "TW", "TW"},
+	}
+	for _, test := range tests {
+		country := map[string]string{"country_name": test.name, "country_code": test.code}
+		applyGeoOverrides(country)
+		if country["country_name"] != test.wantName || country["country_code"] != test.wantCode {
+			t.Errorf("applyGeoOverrides(%v) = %v/%v, want %v/%v", test.name,
+				country["country_name"], country["country_code"], test.wantName, test.wantCode)
+		}
+	}
+}
@@ -5,11 +5,15 @@ go 1.23.0
 toolchain go1.23.7
 
 require (
+	github.com/beevik/ntp v1.4.3
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/gomodule/redigo v1.9.2
 	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/shirou/gopsutil/v4 v4.25.2
+	golang.org/x/sys v0.31.0
 )
 
 require (
@@ -20,5 +24,5 @@ require (
 	github.com/tklauser/go-sysconf v0.3.15 // indirect
 	github.com/tklauser/numcpus v0.10.0 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
-	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
 )
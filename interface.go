@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/net"
+)
+
+type interfaceStat struct {
+	OperState string   `json:"operstate"`
+	Carrier   string   `json:"carrier"`
+	Speed     string   `json:"speed"`
+	Duplex    string   `json:"duplex"`
+	MTU       int      `json:"mtu"`
+	MAC       string   `json:"mac"`
+	IPV4      []string `json:"ipv4"`
+	IPV6      []string `json:"ipv6"`
+}
+
+var INTERFACE_FORMER map[string]interfaceStat = nil
+
+func readInterfaceAttr(name, attr string) string {
+	// Not every driver implements speed/duplex, reading them may fail with EINVAL
+	value, ok := readSingleValue(filepath.Join(SYSFS_PATH, "class", "net", name, attr))
+	if !ok {
+		return "unknown"
+	}
+	return value
+}
+
+func diffInterfaces(current map[string]interfaceStat) []string {
+	events := []string{}
+	if INTERFACE_FORMER == nil {
+		return events
+	}
+
+	for name, stat := range current {
+		former, ok := INTERFACE_FORMER[name]
+		if !ok {
+			events = append(events, fmt.Sprintf("%v added", name))
+			continue
+		}
+		if former.OperState != stat.OperState || former.Carrier != stat.Carrier {
+			events = append(events, fmt.Sprintf("%v link %v -> %v", name, former.OperState, stat.OperState))
+		}
+		if !slices.Equal(former.IPV4, stat.IPV4) || !slices.Equal(former.IPV6, stat.IPV6) {
+			events = append(events, fmt.Sprintf("%v address %v -> %v",
+				name, strings.Join(maskIPs(slices.Concat(former.IPV4, former.IPV6)), ","), strings.Join(maskIPs(slices.Concat(stat.IPV4, stat.IPV6)), ",")))
+		}
+	}
+	for name := range INTERFACE_FORMER {
+		if _, ok := current[name]; !ok {
+			events = append(events, fmt.Sprintf("%v removed", name))
+		}
+	}
+
+	slices.Sort(events)
+	for _, event := range events {
+		logMessage(INFO, fmt.Sprintf("Interface changed: %v", event))
+	}
+	return events
+}
+
+func scanInterfaces() (map[string]interfaceStat, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]interfaceStat, len(interfaces))
+	for _, iface := range interfaces {
+		stat := interfaceStat{
+			OperState: readInterfaceAttr(iface.Name, "operstate"),
+			Carrier:   readInterfaceAttr(iface.Name, "carrier"),
+			Speed:     readInterfaceAttr(iface.Name, "speed"),
+			Duplex:    readInterfaceAttr(iface.Name, "duplex"),
+			MTU:       iface.MTU,
+			MAC:       iface.HardwareAddr,
+			IPV4:      []string{},
+			IPV6:      []string{},
+		}
+		for _, addr := range iface.Addrs {
+			if strings.Contains(addr.Addr, ":") {
+				stat.IPV6 = append(stat.IPV6, addr.Addr)
+			} else {
+				stat.IPV4 = append(stat.IPV4, addr.Addr)
+			}
+		}
+		current[iface.Name] = stat
+	}
+	return current, nil
+}
+
+// Changes since the former report go into the sample so they are kept with
+// the history, the inventory itself is reported by getInterfaces
+func getInterfaceEvents() string {
+	current, err := scanInterfaces()
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to list interfaces: %v", err))
+		return "[]"
+	}
+
+	events := diffInterfaces(current)
+	INTERFACE_FORMER = current
+
+	data, _ := json.Marshal(events)
+	return string(data)
+}
+
+func getInterfaces() string {
+	// Get local interface inventory as of the latest getInterfaceEvents
+	masked := make(map[string]interfaceStat, len(INTERFACE_FORMER))
+	for name, stat := range INTERFACE_FORMER {
+		stat.IPV4 = maskIPs(stat.IPV4)
+		stat.IPV6 = maskIPs(stat.IPV6)
+		masked[name] = stat
+	}
+
+	data, _ := json.Marshal(masked)
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
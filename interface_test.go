@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffInterfaces(t *testing.T) {
+	oldFormer, oldPrivacy := INTERFACE_FORMER, IP_PRIVACY
+	INTERFACE_FORMER, IP_PRIVACY = nil, "none"
+	t.Cleanup(func() { INTERFACE_FORMER, IP_PRIVACY = oldFormer, oldPrivacy })
+
+	up := interfaceStat{OperState: "up", Carrier: "1", IPV4: []string{"192.0.2.1/24"}, IPV6: []string{"2001:db8::1/64"}}
+	current := map[string]interfaceStat{"eth0": up, "eth1": up, "lo": {OperState: "unknown", Carrier: "1"}}
+
+	// Nothing to compare against on the first report
+	if events := diffInterfaces(current); len(events) != 0 {
+		t.Errorf("diffInterfaces() = %v on the first report, want none", events)
+	}
+	INTERFACE_FORMER = current
+
+	if events := diffInterfaces(current); len(events) != 0 {
+		t.Errorf("diffInterfaces() = %v without changes, want none", events)
+	}
+
+	down := up
+	down.OperState, down.Carrier = "down", "0"
+	noCarrier := up
+	noCarrier.Carrier = "0"
+	moved := up
+	moved.IPV4 = []string{"192.0.2.2/24"}
+	next := map[string]interfaceStat{
+		"eth0": down,
+		"eth1": moved,
+		"lo":   current["lo"],
+		"wg0":  up,
+	}
+	want := []string{
+		"eth0 link up -> down",
+		"eth1 address 192.0.2.1/24,2001:db8::1/64 -> 192.0.2.2/24,2001:db8::1/64",
+		"wg0 added",
+	}
+	if events := diffInterfaces(next); !reflect.DeepEqual(events, want) {
+		t.Errorf("diffInterfaces() = %v, want %v", events, want)
+	}
+
+	// A lost carrier counts as a link change even if operstate lags behind
+	want = []string{"eth0 link up -> up", "eth1 removed", "lo removed"}
+	if events := diffInterfaces(map[string]interfaceStat{"eth0": noCarrier}); !reflect.DeepEqual(events, want) {
+		t.Errorf("diffInterfaces() = %v, want %v", events, want)
+	}
+}
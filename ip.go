@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	IPV4_SOURCE = "none"
+	IPV6_SOURCE = "none"
+)
+
+// Returns the address only if it parses as an IP of the wanted family
+func validateIP(value string, v6 bool) string {
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil || (ip.To4() == nil) != v6 {
+		return ""
+	}
+	return ip.String()
+}
+
+func getDefaultRouteInterface(v6 bool) string {
+	if !v6 {
+		// Iface Destination Gateway Flags ...
+		file, err := os.ReadFile(filepath.Join(PROCFS_PATH, "net", "route"))
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(file), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) > 1 && fields[1] == "00000000" {
+				return fields[0]
+			}
+		}
+		return ""
+	}
+
+	// Destination PrefixLen Source PrefixLen NextHop Metric RefCnt Use Flags Iface
+	file, err := os.ReadFile(filepath.Join(PROCFS_PATH, "net", "ipv6_route"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(file), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 10 && fields[0] == strings.Repeat("0", 32) && fields[1] == "00" && fields[9] != "lo" {
+			return fields[9]
+		}
+	}
+	return ""
+}
+
+func getRouteIP(v6 bool) string {
+	name := getDefaultRouteInterface(v6)
+	if name == "" {
+		return ""
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ""
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ip := validateIP(ipNet.IP.String(), v6); ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+func getUDPSourceIP(v6 bool) string {
+	// Connecting a UDP socket sends nothing, it only asks the kernel for the source address
+	network, target := "udp4", IP_PROBE_V4
+	if v6 {
+		network, target = "udp6", IP_PROBE_V6
+	}
+	conn, err := net.Dial(network, target)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	if !ip.IsGlobalUnicast() {
+		return ""
+	}
+	return validateIP(ip.String(), v6)
+}
+
+func getAPIIP(v6 bool) string {
+	api := IPV4_API
+	if v6 {
+		api = IPV6_API
+	}
+	if api == "" {
+		return ""
+	}
+	data, err := getRequest(api, map[string]string{"User-Agent": USER_AGENT})
+	if err != nil {
+		return ""
+	}
+	ip := validateIP(data, v6)
+	if ip == "" {
+		logMessage(ERROR, fmt.Sprintf("Invalid IP address from %v: %.64q", api, maskIPText(data)))
+	}
+	return ip
+}
+
+func discoverIP(v6 bool) (string, string) {
+	static := STATIC_IPV4
+	if v6 {
+		static = STATIC_IPV6
+	}
+
+	// Addresses behind NAT are kept in case no later source finds a public
+	// one, so air-gapped hosts still report their private address
+	fallback, fallbackSource := "", ""
+	for _, source := range IP_DISCOVERY {
+		ip := ""
+		switch source {
+		case "static":
+			ip = validateIP(static, v6)
+		case "route":
+			ip = getRouteIP(v6)
+		case "udp":
+			ip = getUDPSourceIP(v6)
+		case "api":
+			ip = getAPIIP(v6)
+		default:
+			logMessage(ERROR, fmt.Sprintf("Unknown IP discovery source: %v", source))
+		}
+		if ip == "" {
+			continue
+		}
+		if source != "static" && !IP_ALLOW_PRIVATE && net.ParseIP(ip).IsPrivate() {
+			if fallback == "" {
+				fallback, fallbackSource = ip, source
+			}
+			continue
+		}
+		return ip, source
+	}
+	if fallback != "" {
+		return fallback, fallbackSource
+	}
+	return "None", "none"
+}
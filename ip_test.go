@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetDefaultRouteInterface(t *testing.T) {
+	root := setProcfsPath(t)
+	if name := getDefaultRouteInterface(false); name != "" {
+		t.Errorf("getDefaultRouteInterface() = %v without procfs, want none", name)
+	}
+
+	writeFixture(t, filepath.Join(root, "net", "route"),
+		"Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n"+
+			"docker0\t000011AC\t00000000\t0001\t0\t0\t0\t0000FFFF\t0\t0\t0\n"+
+			"eth0\t00000000\t0100000A\t0003\t0\t0\t100\t00000000\t0\t0\t0\n"+
+			"eth0\t0000000A\t00000000\t0001\t0\t0\t100\t00FFFFFF\t0\t0\t0\n")
+	writeFixture(t, filepath.Join(root, "net", "ipv6_route"),
+		"00000000000000000000000000000001 80 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000001 00000000 00200001       lo\n"+
+			"20010db8000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth1\n"+
+			"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     eth1\n"+
+			// Unreachable default route on loopback
+			"00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo\n")
+
+	if name := getDefaultRouteInterface(false); name != "eth0" {
+		t.Errorf("getDefaultRouteInterface(v4) = %v, want eth0", name)
+	}
+	if name := getDefaultRouteInterface(true); name != "eth1" {
+		t.Errorf("getDefaultRouteInterface(v6) = %v, want eth1", name)
+	}
+}
+
+func setIPDiscovery(t *testing.T, sources ...string) {
+	t.Helper()
+	oldDiscovery, oldStatic, oldAPI, oldAllow := IP_DISCOVERY, STATIC_IPV4, IPV4_API, IP_ALLOW_PRIVATE
+	IP_DISCOVERY, STATIC_IPV4, IPV4_API, IP_ALLOW_PRIVATE = sources, "", "", false
+	t.Cleanup(func() {
+		IP_DISCOVERY, STATIC_IPV4, IPV4_API, IP_ALLOW_PRIVATE = oldDiscovery, oldStatic, oldAPI, oldAllow
+	})
+}
+
+func TestDiscoverIPStatic(t *testing.T) {
+	setIPDiscovery(t, "static")
+
+	// A configured address is trusted even if it is private
+	STATIC_IPV4 = "10.0.0.5"
+	if ip, source := discoverIP(false); ip != "10.0.0.5" || source != "static" {
+		t.Errorf("discoverIP() = %v, %v, want 10.0.0.5, static", ip, source)
+	}
+
+	STATIC_IPV4 = "2001:db8::10"
+	if ip, source := discoverIP(false); ip != "None" || source != "none" {
+		t.Errorf("discoverIP() = %v, %v, want None, none", ip, source)
+	}
+}
+
+func TestDiscoverIPPrivate(t *testing.T) {
+	api := "10.0.0.5"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, api)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		static     string
+		allow      bool
+		wantIP     string
+		wantSource string
+	}{
+		// A public address from a later source wins over a private one
+		{"public later", "203.0.113.10", false, "203.0.113.10", "static"},
+		// Air-gapped hosts fall back to the first private address
+		{"private only", "", false, "10.0.0.5", "api"},
+		{"allowed", "203.0.113.10", true, "10.0.0.5", "api"},
+	}
+	for _, test := range tests {
+		setIPDiscovery(t, "api", "static")
+		IPV4_API, STATIC_IPV4, IP_ALLOW_PRIVATE = server.URL, test.static, test.allow
+		if ip, source := discoverIP(false); ip != test.wantIP || source != test.wantSource {
+			t.Errorf("%v: discoverIP() = %v, %v, want %v, %v", test.name, ip, source, test.wantIP, test.wantSource)
+		}
+	}
+
+	// Public addresses are taken right away
+	setIPDiscovery(t, "api", "static")
+	IPV4_API, STATIC_IPV4, api = server.URL, "203.0.113.10", "198.51.100.7"
+	if ip, source := discoverIP(false); ip != "198.51.100.7" || source != "api" {
+		t.Errorf("discoverIP() = %v, %v, want 198.51.100.7, api", ip, source)
+	}
+}
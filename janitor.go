@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var JANITOR_FORMER_TIME int64 = 0
+
+func getStaleHosts(hostsKey string, before int64) ([]string, error) {
+	conn := getRedisConn(hostsKey)
+	if conn == nil {
+		return nil, errors.New("fail to connect to Redis")
+	}
+	defer conn.Close()
+	return redis.Strings(conn.Do("ZRANGEBYSCORE", hostsKey, 0, before))
+}
+
+// Deletes the keys passed for each role in ARGV[1] unless the host reported
+// after ARGV[2], the registry and legacy hashes only lose the host entry.
+// Cluster mode runs it once on the host keys and once on the registry,
+// standalone runs both in one call.
+const JANITOR_LUA = `
+local keys = {}
+local data = {}
+local i = 1
+for role in string.gmatch(ARGV[1], '[^,]+') do
+	keys[role] = KEYS[i]
+	if role ~= 'hosts' and role ~= 'hashes' and role ~= 'schema' then
+		table.insert(data, KEYS[i])
+	end
+	i = i + 1
+end
+local before = tonumber(ARGV[2])
+local uuid = ARGV[3]
+
+if keys.hosts then
+	local score = redis.call('ZSCORE', keys.hosts, uuid)
+	if score and tonumber(score) > before then
+		return 0
+	end
+end
+if keys.host then
+	local seen = redis.call('HGET', keys.host, 'last_seen')
+	if seen and tonumber(seen) > before then
+		return 0
+	end
+end
+
+if #data > 0 then
+	redis.call('DEL', unpack(data))
+end
+if keys.hosts then
+	redis.call('ZREM', keys.hosts, uuid)
+end
+for _, role in ipairs({'hashes', 'schema'}) do
+	if keys[role] then
+		redis.call('HDEL', keys[role], uuid)
+	end
+end
+return 1
+`
+
+var JANITOR_SCRIPT = redis.NewScript(-1, JANITOR_LUA)
+
+func checkJanitorConfig() {
+	if DATA_TIMEOUT <= 0 {
+		logMessage(ERROR, "DATA_TIMEOUT is not positive, janitor disabled")
+		JANITOR = false
+		return
+	}
+	if DATA_TIMEOUT >= RETENTION_TIME {
+		logMessage(ERROR, fmt.Sprintf("DATA_TIMEOUT (%v) should be smaller than RETENTION_TIME (%v), "+
+			"info and collection keys expire before the janitor sees the host as stale", DATA_TIMEOUT, RETENTION_TIME))
+	}
+}
+
+// Removes every key of a host and its registry entry, unless the host
+// reported since the registry was read.
+func cleanHost(hostsKey, uuid string, before int64) (bool, error) {
+	report := redisReport{}
+	// Per host keys share a hash tag in cluster mode, one call reaches them all
+	for _, kind := range []string{"host", "info", "collection", "stream", "alive"} {
+		report.add(kind, getRedisHostKeyOf(kind, uuid))
+	}
+	for _, rollup := range ROLLUP_INTERVALS {
+		role := fmt.Sprintf("rollup:%v", rollup.interval)
+		report.add(role, getRedisHostKeyOf(role, uuid))
+	}
+
+	reports := []redisReport{report}
+	if REDIS_MODE == "cluster" {
+		// Host keys go first, a host that reports afterwards is still in the registry
+		reports = append(reports, redisReport{})
+	}
+	registry := &reports[len(reports)-1]
+	registry.add("hosts", hostsKey)
+	if REDIS_LEGACY_HASHES {
+		registry.add("hashes", getRedisKey("hashes"))
+		registry.add("schema", getRedisKey("schema"))
+	}
+
+	for _, r := range reports {
+		conn := getRedisConn(r.keys[0])
+		if conn == nil {
+			return false, errors.New("fail to connect to Redis")
+		}
+		args := redis.Args{len(r.keys)}.AddFlat(r.keys).Add(strings.Join(r.roles, ","), before, uuid)
+		cleaned, err := redis.Int(JANITOR_SCRIPT.Do(conn, args...))
+		conn.Close()
+		if err != nil {
+			return false, err
+		}
+		if cleaned == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Cleans up hosts whose last sample is older than DATA_TIMEOUT, at most
+// once per JANITOR_INTERVAL.
+func runJanitor() {
+	now := time.Now().Unix()
+	if now-JANITOR_FORMER_TIME < int64(JANITOR_INTERVAL) {
+		return
+	}
+	JANITOR_FORMER_TIME = now
+
+	hostsKey := getRedisKey("hosts")
+	before := now - int64(DATA_TIMEOUT)
+	hosts, err := getStaleHosts(hostsKey, before)
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to list stale hosts: %v", err))
+		return
+	}
+
+	for _, uuid := range hosts {
+		cleaned, err := cleanHost(hostsKey, uuid, before)
+		if err != nil {
+			logMessage(ERROR, fmt.Sprintf("Fail to clean up host %v: %v", uuid, err))
+			continue
+		}
+		if cleaned {
+			logMessage(INFO, fmt.Sprintf("Cleaned up stale host %v", uuid))
+		}
+	}
+}
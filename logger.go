@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
 )
 
 const (
@@ -12,7 +13,7 @@ const (
 )
 
 var (
-	logger   *log.Logger
+	logger   = log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
 	logLevel = INFO
 )
 
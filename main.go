@@ -13,7 +13,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gomodule/redigo/redis"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/robfig/cron/v3"
@@ -49,7 +48,58 @@ var (
 	SYSTEM_VERSION        string
 	UPTIME                string
 	ALIVE_CHECK_TIME      int
-	IPINFO_API            = []string{"https://ipwhois.app/json/", "https://reallyfreegeoip.org/json/"}
+	PROCESS_TOP_N         int
+	PROCESS_CMDLINE_LEN   int
+	PROCESS_REDACT        *regexp.Regexp
+	PROCESS_WATCHES       []processWatch
+	SYSTEMD_BUS           string
+	SYSTEMD_UNITS         []string
+	CONTAINER_SOCKET      string
+	CONTAINER_INCLUDE     []string
+	CONTAINER_EXCLUDE     []string
+	CGROUP_ROOT           string
+	CGROUP_PATHS          []string
+	CGROUP_DEPTH          int
+	PROCFS_PATH           string
+	SYSFS_PATH            string
+	IP_DISCOVERY          []string
+	STATIC_IPV4           string
+	STATIC_IPV6           string
+	IP_PROBE_V4           string
+	IP_PROBE_V6           string
+	IP_ALLOW_PRIVATE      bool
+	IP_PRIVACY            string
+	IP_PRIVACY_PREFIX_V4  int
+	IP_PRIVACY_PREFIX_V6  int
+	IP_PRIVACY_SALT       string
+	NTP_SERVER            string
+	CLOCK_OFFSET_WARN     int
+	REDIS_MAX_IDLE        int
+	REDIS_IDLE_TIMEOUT    int
+	REDIS_MODE            string
+	REDIS_SENTINELS       []string
+	REDIS_MASTER          string
+	SENTINEL_PASSWORD     string
+	REDIS_CLUSTER_NODES   []string
+	REDIS_USERNAME        string
+	REDIS_DB              int
+	REDIS_TLS_CA          string
+	REDIS_TLS_CERT        string
+	REDIS_TLS_KEY         string
+	REDIS_TLS_SERVER_NAME string
+	REDIS_TLS_SKIP_VERIFY bool
+	REDIS_PREFIX          string
+	REDIS_STORAGE         string
+	REDIS_STREAM_MAXLEN   int
+	REDIS_FLEET_STREAM    bool
+	REDIS_PUBLISH         bool
+	REDIS_LEGACY_HASHES   bool
+	ROLLUP_INTERVALS      []rollupInterval
+	JANITOR               bool
+	JANITOR_INTERVAL      int
+	GEOIP_PROVIDERS       []geoProvider
+	GEOIP_OVERRIDES       []geoOverride
+	GEOIP_CACHE_TTL       int
 )
 
 func loadUUID(execDir string) string {
@@ -67,7 +117,8 @@ func loadUUID(execDir string) string {
 	return strings.TrimSpace(string(file))
 }
 
-func init() {
+// Reads .env and the environment into the settings above
+func loadConfig() {
 	execPath, err := os.Executable()
 	if err != nil {
 		log.Fatalf("Error getting executable path: %v", err)
@@ -80,20 +131,36 @@ func init() {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	HOST = getEnv("HOST", "localhost")
-	PORT = getEnv("PORT", "6379")
-	SSL, _ = strconv.ParseBool(getEnv("SSL", "false"))
+	REDIS_URL_OPTIONS, err = parseRedisURL(getEnv("REDIS_URL", ""))
+	if err != nil {
+		log.Fatalf("Invalid REDIS_URL: %v", err)
+	}
+	HOST = getRedisEnv("HOST", "localhost")
+	PORT = getRedisEnv("PORT", "6379")
+	SSL, _ = strconv.ParseBool(getRedisEnv("SSL", "false"))
 	REPORT_ONCE, _ = strconv.ParseBool(getEnv("REPORT_ONCE", "false"))
 	SOCKET_TIMEOUT, _ = strconv.Atoi(getEnv("SOCKET_TIMEOUT", "10"))
 	REPORT_TIME, _ = strconv.Atoi(getEnv("REPORT_TIME", "60"))
-	RETENTION_TIME, _ = strconv.Atoi(getEnv("RETENTION_TIME", "86400"))   // 1 day
-	DATA_TIMEOUT, _ = strconv.Atoi(getEnv("DATA_TIMEOUT", "259200"))      // 3 days
-	ALIVE_CHECK_TIME, _ = strconv.Atoi(getEnv("ALIVE_CHECK_TIME", "600")) // 10 minutes
+	RETENTION_TIME, _ = strconv.Atoi(getEnv("RETENTION_TIME", "86400"))                 // 1 day
+	DATA_TIMEOUT, _ = strconv.Atoi(getEnv("DATA_TIMEOUT", getEnv("TIMEOUT", "259200"))) // 3 days
+	ALIVE_CHECK_TIME, _ = strconv.Atoi(getEnv("ALIVE_CHECK_TIME", "600"))               // 10 minutes
 	SERVER_URL = getEnv("SERVER_URL", "http://localhost:8000")
 	REPORT_MODE = strings.ToLower(getEnv("REPORT_MODE", "redis"))
 	SERVER_TOKEN = getEnv("SERVER_TOKEN", "")
-	PASSWORD = getEnv("PASSWORD", "")
+	PASSWORD = getRedisEnv("PASSWORD", "")
 	LOG_LEVEL = getEnv("LOG_LEVEL", "INFO")
+	PROCESS_TOP_N, _ = strconv.Atoi(getEnv("PROCESS_TOP_N", "5"))
+	PROCESS_CMDLINE_LEN, _ = strconv.Atoi(getEnv("PROCESS_CMDLINE_LEN", "128"))
+	SYSTEMD_BUS = getEnv("SYSTEMD_BUS", "unix:path=/run/systemd/private")
+	SYSTEMD_UNITS = parseSystemdUnits(getEnvList("SYSTEMD_UNITS", ""))
+	CONTAINER_SOCKET = getEnv("CONTAINER_SOCKET", "/var/run/docker.sock")
+	CONTAINER_INCLUDE = getEnvList("CONTAINER_INCLUDE", "")
+	CONTAINER_EXCLUDE = getEnvList("CONTAINER_EXCLUDE", "")
+	PROCFS_PATH = getEnv("PROCFS_PATH", "/proc")
+	SYSFS_PATH = getEnv("SYSFS_PATH", "/sys")
+	CGROUP_ROOT = getEnv("CGROUP_ROOT", "/sys/fs/cgroup")
+	CGROUP_PATHS = getEnvList("CGROUP_PATHS", "")
+	CGROUP_DEPTH, _ = strconv.Atoi(getEnv("CGROUP_DEPTH", "0"))
 	UUID = loadUUID(execDir)
 
 	SERVER_URL_INFO = fmt.Sprintf("%s/api/report/info/%s", SERVER_URL, UUID)
@@ -103,33 +170,62 @@ func init() {
 
 	IPV4_API = getEnv("IPV4_API", "https://4.ident.me/")
 	IPV6_API = getEnv("IPV6_API", "https://6.ident.me/")
+	IP_DISCOVERY = getEnvList("IP_DISCOVERY", "static,route,udp,api")
+	STATIC_IPV4 = getEnv("STATIC_IPV4", "")
+	STATIC_IPV6 = getEnv("STATIC_IPV6", "")
+	IP_PROBE_V4 = getEnv("IP_PROBE_V4", "8.8.8.8:53")
+	IP_PROBE_V6 = getEnv("IP_PROBE_V6", "[2001:4860:4860::8888]:53")
+	IP_ALLOW_PRIVATE, _ = strconv.ParseBool(getEnv("IP_ALLOW_PRIVATE", "false"))
+	GEOIP_CACHE_TTL, _ = strconv.Atoi(getEnv("GEOIP_CACHE_TTL", "86400"))
+	IP_PRIVACY = strings.ToLower(getEnv("IP_PRIVACY", "partial"))
+	IP_PRIVACY_PREFIX_V4, _ = strconv.Atoi(getEnv("IP_PRIVACY_PREFIX_V4", "24"))
+	IP_PRIVACY_PREFIX_V6, _ = strconv.Atoi(getEnv("IP_PRIVACY_PREFIX_V6", "112"))
+	IP_PRIVACY_SALT = getEnv("IP_PRIVACY_SALT", UUID)
+	NTP_SERVER = getEnv("NTP_SERVER", "")
+	CLOCK_OFFSET_WARN, _ = strconv.Atoi(getEnv("CLOCK_OFFSET_WARN", "1000"))
+	REDIS_MAX_IDLE, _ = strconv.Atoi(getEnv("REDIS_MAX_IDLE", "2"))
+	REDIS_IDLE_TIMEOUT, _ = strconv.Atoi(getEnv("REDIS_IDLE_TIMEOUT", "300"))
+	REDIS_MODE = getEnv("REDIS_MODE", "standalone")
+	REDIS_PREFIX = strings.TrimSuffix(getEnv("REDIS_PREFIX", "system_monitor"), ":")
+	REDIS_STORAGE = strings.ToLower(getEnv("REDIS_STORAGE", "zset"))
+	REDIS_STREAM_MAXLEN, _ = strconv.Atoi(getEnv("REDIS_STREAM_MAXLEN", "0"))
+	REDIS_FLEET_STREAM, _ = strconv.ParseBool(getEnv("REDIS_FLEET_STREAM", "false"))
+	REDIS_PUBLISH, _ = strconv.ParseBool(getEnv("REDIS_PUBLISH", "false"))
+	REDIS_LEGACY_HASHES, _ = strconv.ParseBool(getEnv("REDIS_LEGACY_HASHES", "true"))
+	JANITOR, _ = strconv.ParseBool(getEnv("JANITOR", "false"))
+	JANITOR_INTERVAL, _ = strconv.Atoi(getEnv("JANITOR_INTERVAL", "3600"))
+	REDIS_SENTINELS = getEnvList("REDIS_SENTINELS", "")
+	REDIS_MASTER = getEnv("REDIS_MASTER", "mymaster")
+	SENTINEL_PASSWORD = getEnv("SENTINEL_PASSWORD", "")
+	REDIS_CLUSTER_NODES = getEnvList("REDIS_CLUSTER_NODES", HOST+":"+PORT)
+	REDIS_USERNAME = getRedisEnv("REDIS_USERNAME", "")
+	REDIS_DB, _ = strconv.Atoi(getRedisEnv("REDIS_DB", "0"))
+	REDIS_TLS_CA = getEnv("REDIS_TLS_CA", "")
+	REDIS_TLS_CERT = getEnv("REDIS_TLS_CERT", "")
+	REDIS_TLS_KEY = getEnv("REDIS_TLS_KEY", REDIS_TLS_CERT)
+	REDIS_TLS_SERVER_NAME = getEnv("REDIS_TLS_SERVER_NAME", "")
+	REDIS_TLS_SKIP_VERIFY, _ = strconv.ParseBool(getEnv("REDIS_TLS_SKIP_VERIFY", "false"))
 
-	// Initialize logger
-	logger = log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
 	setLogLevel(LOG_LEVEL)
 
-	getIP()
-	getCountry()
-	cronJob := cron.New()
-	_, err = cronJob.AddFunc("@hourly", func() {
-		logMessage(INFO, "Updating IP Address")
-		getIP()
-	})
-	if err != nil {
-		logMessage(ERROR, fmt.Sprintf("Error adding cron job 'getIP()': %v", err))
+	if REPORT_MODE == "redis" {
+		logRedisSettingSources()
 	}
-	_, err = cronJob.AddFunc("@hourly", func() {
-		logMessage(INFO, "Updating Country Information")
-		getCountry()
-	})
-	if err != nil {
-		logMessage(ERROR, fmt.Sprintf("Error adding cron job 'getCountry()': %v", err))
+
+	PROCESS_WATCHES = parseProcessWatch(getEnv("PROCESS_WATCH", ""))
+	PROCESS_REDACT = parseProcessRedact(getEnv("PROCESS_REDACT", ""))
+	ROLLUP_INTERVALS = parseRollupIntervals(getEnvList("ROLLUP_INTERVALS", "300:604800,3600:2592000"))
+	if JANITOR {
+		checkJanitorConfig()
 	}
-	cronJob.Start()
+	GEOIP_PROVIDERS = parseGeoProviders(
+		getEnvList("GEOIP_PROVIDER", "online"),
+		getEnv("GEOIP_API", "https://ipwhois.app/json/;https://reallyfreegeoip.org/json/"),
+		getEnv("GEOIP_DB", ""),
+		getEnv("GEOIP_ASN_DB", ""),
+	)
+	GEOIP_OVERRIDES = parseGeoOverrides(getEnv("GEOIP_OVERRIDE", "Hong Kong={name}, SAR;Macau={name}, SAR;Taiwan={name} Province|CN"))
 
-	CPU = getCPUInfo()
-	SYSTEM_VERSION = getSysVersion()
-	UPTIME = getUptime()
 }
 
 func getEnv(key, defaultValue string) string {
@@ -140,34 +236,37 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
-func getRedisConn() redis.Conn {
-	// Connect to Redis
-	conn, err := redis.Dial(
-		"tcp",
-		fmt.Sprintf("%v:%v", HOST, PORT),
-		redis.DialUseTLS(SSL),
-		redis.DialConnectTimeout(time.Duration(SOCKET_TIMEOUT)*time.Second),
-		redis.DialPassword(PASSWORD),
-	)
-	if err != nil {
-		logMessage(ERROR, fmt.Sprintf("Error connecting to Redis: %v", err))
-		return nil
+func getEnvList(key, defaultValue string) []string {
+	values := []string{}
+	for _, value := range strings.Split(getEnv(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
 	}
-
-	return conn
+	return values
 }
 
 func getAggregateStat() map[string]interface{} {
 	aggregateStat := map[string]interface{}{
-		"Battery": json.RawMessage("{}"),
-		"Disk":    json.RawMessage(getDiskInfo()),
-		"Fan":     json.RawMessage("{}"),
-		"IO":      json.RawMessage(getIO()),
-		"Load":    json.RawMessage(getLoad()),
-		"Memory":  json.RawMessage(getMemInfo()),
-		"Network": json.RawMessage(getNetwork()),
-		"Ping":    json.RawMessage("{}"),
-		"Thermal": json.RawMessage(getTemperature()),
+		"Battery":    json.RawMessage("{}"),
+		"Cgroup":     json.RawMessage(getCgroups()),
+		"Clock":      json.RawMessage(getClock()),
+		"Connection": json.RawMessage(getConnectionDetail()),
+		"Container":  json.RawMessage(getContainers()),
+		"Disk":       json.RawMessage(getDiskInfo()),
+		"Fan":        json.RawMessage("{}"),
+		"IO":         json.RawMessage(getIO()),
+		"Interface":  json.RawMessage(getInterfaceEvents()),
+		"Load":       json.RawMessage(getLoad()),
+		"Memory":     json.RawMessage(getMemInfo()),
+		"NetStack":   json.RawMessage(getNetStack()),
+		"Network":    json.RawMessage(getNetwork()),
+		"Ping":       json.RawMessage("{}"),
+		"Pressure":   json.RawMessage(getPressure()),
+		"Process":    json.RawMessage(getTopProcess()),
+		"Systemd":    json.RawMessage(getSystemd()),
+		"Thermal":    json.RawMessage(getTemperature()),
+		"Watch":      json.RawMessage(getProcessWatch()),
 	}
 
 	return aggregateStat
@@ -176,19 +275,25 @@ func getAggregateStat() map[string]interface{} {
 func getInfo() map[string]interface{} {
 	UPTIME = getUptime()
 	info := map[string]interface{}{
-		"Connection":     getConnections(),
-		"Country":        COUNTRY["country_name"],
-		"Country Code":   COUNTRY["country_code"],
-		"CPU":            CPU,
-		"IPV4":           replaceString(IPV4, "\\d*\\.\\d*\\.\\d*", "*.*.*"),
-		"IPV6":           replaceString(IPV6, "[a-fA-F0-9]*:", "*:"),
-		"Load Average":   getLoadAvg(),
-		"Process":        getProcessNum(),
-		"System Version": SYSTEM_VERSION,
-		"Throughput":     getThroughput(),
-		"Update Time":    time.Now().Unix(),
-		"Uptime":         UPTIME,
-		"Agent Version":  VERSION,
+		"Connection":       getConnections(),
+		"Country":          COUNTRY["country_name"],
+		"Country Code":     COUNTRY["country_code"],
+		"City":             COUNTRY["city"],
+		"ASN":              COUNTRY["asn"],
+		"Organization":     COUNTRY["org"],
+		"CPU":              CPU,
+		"IPV4":             maskIP(IPV4),
+		"IPV4 Source":      IPV4_SOURCE,
+		"IPV6":             maskIP(IPV6),
+		"IPV6 Source":      IPV6_SOURCE,
+		"Interfaces":       json.RawMessage(getInterfaces()),
+		"Load Average":     getLoadAvg(),
+		"Process":          getProcessNum(),
+		"System Version":   SYSTEM_VERSION,
+		"Throughput":       getThroughput(),
+		"Update Time":      time.Now().Unix(),
+		"Uptime":           UPTIME,
+		"Agent Version":    VERSION,
 	}
 
 	return info
@@ -232,7 +337,8 @@ func postRequest(url string, headers map[string]string, data string) (string, er
 		return "", err
 	}
 
-	logMessage(DEBUG, string(body))
+	// Bodies of the IP and geo lookups carry the address of this host
+	logMessage(DEBUG, maskIPText(string(body)))
 	return string(body), nil
 }
 
@@ -265,83 +371,33 @@ func getRequest(url string, headers map[string]string) (string, error) {
 		return "", err
 	}
 
-	logMessage(DEBUG, string(body))
+	// Bodies of the IP and geo lookups carry the address of this host
+	logMessage(DEBUG, maskIPText(string(body)))
 	return string(body), nil
 }
 
-func replaceString(input, pattern, replacement string) string {
-	re := regexp.MustCompile(pattern)
-	return re.ReplaceAllString(input, replacement)
-}
-
 func getIP() {
-	var err error
-	IPV4, err = getRequest(IPV4_API, map[string]string{})
-	if err != nil {
-		IPV4 = "None"
-	}
-	IPV6, err = getRequest(IPV6_API, map[string]string{})
-	if err != nil {
-		IPV6 = "None"
-	}
+	IPV4, IPV4_SOURCE = discoverIP(false)
+	IPV6, IPV6_SOURCE = discoverIP(true)
 
-	logMessage(INFO, IPV4)
-	logMessage(INFO, IPV6)
+	logMessage(INFO, fmt.Sprintf("%v (%v)", maskIP(IPV4), IPV4_SOURCE))
+	logMessage(INFO, fmt.Sprintf("%v (%v)", maskIP(IPV6), IPV6_SOURCE))
 }
 
 func getCountry() {
-	COUNTRY = map[string]string{
-		"country_name": "Unknown",
-		"country_code": "Unknown",
-	}
-
-	var (
-		data string
-		err  error
-	)
-
-	for _, url := range IPINFO_API {
-		logMessage(INFO, fmt.Sprintf("Fetching country from %v", url))
-		data, err = getRequest(url, map[string]string{})
-		if err != nil {
-			logMessage(ERROR, fmt.Sprintf("Fail to fetch country from %v", url))
-			continue
-		} 
-		break
-	}
-
-	if err != nil {
-		logMessage(ERROR, "Fail to fetch country")
-		return
-	}
-
-	logMessage(DEBUG, data)
-
-	var country map[string]interface{}
-	err = json.Unmarshal([]byte(data), &country)
-	if err != nil {
-		logMessage(ERROR, "Fail to parse country")
-		return
-	}
-
-	
-	COUNTRY = map[string]string{
-		"country_name": FirstNonEmpty("Unknown", country["country_name"], country["country"]).(string),
-		"country_code": FirstNonEmpty("Unknown", country["country_code"], country["countryCode"]).(string),
-	}
-
-	if strings.Contains(COUNTRY["country_name"], "Hong Kong") ||
-		strings.Contains(COUNTRY["country_name"], "Macau") {
-		COUNTRY["country_name"] = COUNTRY["country_name"] + ", SAR"
-	} else if strings.Contains(COUNTRY["country_name"], "Taiwan") {
-		COUNTRY["country_name"] = COUNTRY["country_name"] + " Province"
-		COUNTRY["country_code"] = "CN"
+	country := lookupCountry()
+	if country == nil {
+		country = map[string]string{
+			"country_name": "Unknown",
+			"country_code": "Unknown",
+		}
 	}
-
+	COUNTRY = country
 }
 
 func report() {
 	logMessage(INFO, "Start Reporting")
+	// The sample scans the interfaces the info reports
 	aggregateStat := getAggregateStat()
 	info := getInfo()
 
@@ -356,40 +412,26 @@ func report() {
 	logMessage(DEBUG, string(jsonInfo))
 
 	if REPORT_MODE == "redis" {
-
-		conn := getRedisConn()
-		if conn == nil {
-			logMessage(ERROR, "Fail to connect to Redis")
-			return
-		}
-
-		conn.Send("MULTI")
-		conn.Send("HSET", "system_monitor:hashes", UUID, IPV4)
-		for key, value := range info {
-			conn.Send("HSET", "system_monitor:info:"+UUID, key, value)
-		}
-		conn.Send("ZADD", "system_monitor:collection:"+UUID, time.Now().Unix(), string(jsonAggregateStat))
-
-		conn.Send("ZREMRANGEBYSCORE", "system_monitor:collection:"+UUID, 0, time.Now().Unix()-int64(RETENTION_TIME))
-		conn.Send("EXPIRE", "system_monitor:hashes", RETENTION_TIME)
-		conn.Send("EXPIRE", "system_monitor:info:"+UUID, RETENTION_TIME)
-		conn.Send("EXPIRE", "system_monitor:collection:"+UUID, RETENTION_TIME)
-		conn.Send("SETEX", "system_monitor:alive:"+UUID, ALIVE_CHECK_TIME, "1")
-
-		resp, err := conn.Do("EXEC")
-		if err != nil {
+		if err := reportRedis(info, string(jsonAggregateStat)); err != nil {
 			logMessage(ERROR, fmt.Sprintf("Error executing command: %v", err))
 			return
 		}
-		logMessage(DEBUG, fmt.Sprintf("Response: %v", resp))
 		logMessage(INFO, "Finish Reporting")
-		conn.Close()
+		if JANITOR {
+			runJanitor()
+		}
 	}
 	if REPORT_MODE == "http" {
 		if SERVER_TOKEN == "" {
 			log.Fatalf("Please generate server token using `php think token add --uuid %s`", UUID)
 		}
-		postRequest(SERVER_URL_HASH, map[string]string{"User-Agent": USER_AGENT, "Content-Type": "application/json", "authorization": SERVER_TOKEN}, "{\"ip\": \"none\"}")
+		ip := maskIP(IPV4)
+		if ip == "" {
+			ip = "none"
+		}
+		// The server drops data of hosts silent for longer than data_timeout
+		jsonHash, _ := json.Marshal(map[string]interface{}{"ip": ip, "data_timeout": DATA_TIMEOUT})
+		postRequest(SERVER_URL_HASH, map[string]string{"User-Agent": USER_AGENT, "Content-Type": "application/json", "authorization": SERVER_TOKEN}, string(jsonHash))
 		postRequest(SERVER_URL_INFO, map[string]string{"User-Agent": USER_AGENT, "Content-Type": "application/json", "authorization": SERVER_TOKEN}, string(jsonInfo))
 		postRequest(SERVER_URL_COLLECTION, map[string]string{"User-Agent": USER_AGENT, "Content-Type": "application/json", "authorization": SERVER_TOKEN}, string(jsonAggregateStat))
 
@@ -398,6 +440,31 @@ func report() {
 }
 
 func main() {
+	loadConfig()
+
+	getIP()
+	getCountry()
+	cronJob := cron.New()
+	_, err := cronJob.AddFunc("@hourly", func() {
+		logMessage(INFO, "Updating IP Address")
+		getIP()
+	})
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Error adding cron job 'getIP()': %v", err))
+	}
+	_, err = cronJob.AddFunc("@hourly", func() {
+		logMessage(INFO, "Updating Country Information")
+		getCountry()
+	})
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Error adding cron job 'getCountry()': %v", err))
+	}
+	cronJob.Start()
+
+	CPU = getCPUInfo()
+	SYSTEM_VERSION = getSysVersion()
+	UPTIME = getUptime()
+
 	for {
 		report()
 		// getInfo()
@@ -407,4 +474,6 @@ func main() {
 		}
 		break
 	}
+
+	closeRedis()
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 var NET_FORMER []net.IOCountersStat = nil
 var IO_FORMER map[string]interface{} = nil
 var CPU_FORMER []cpu.TimesStat = nil
+var VMSTAT_FORMER map[string]uint64 = nil
 
 func getThroughput() string {
 	counters, _ := net.IOCounters(false)
@@ -259,6 +261,12 @@ func getUptime() string {
 	// uptime, _ := host.Uptime()
 	upTime, _ := host.Uptime()
 
+	uptime := formatUptime(upTime)
+	logMessage(DEBUG, uptime)
+	return uptime
+}
+
+func formatUptime(upTime uint64) string {
 	delta := time.Duration(upTime) * time.Second
 
 	days := int(delta.Hours() / 24)
@@ -266,7 +274,6 @@ func getUptime() string {
 	minutes := int(delta.Minutes()) % 60
 	seconds := int(delta.Seconds()) % 60
 
-	logMessage(DEBUG, fmt.Sprintf("%d Days %02d:%02d:%02d", days, hours, minutes, seconds))
 	return fmt.Sprintf("%d Days %02d:%02d:%02d", days, hours, minutes, seconds)
 }
 
@@ -357,6 +364,21 @@ func getMemInfo() string {
 			"free":    fmt.Sprintf("%.2f", float32(swapMemory.Free)/1024/1024),
 			"percent": fmt.Sprintf("%.2f", swapMemory.UsedPercent),
 		},
+		"Detail": map[string]interface{}{
+			"available":       fmt.Sprintf("%.2f", float32(memory.Available)/1024/1024),
+			"buffers":         fmt.Sprintf("%.2f", float32(memory.Buffers)/1024/1024),
+			"cached":          fmt.Sprintf("%.2f", float32(memory.Cached)/1024/1024),
+			"shared":          fmt.Sprintf("%.2f", float32(memory.Shared)/1024/1024),
+			"slab":            fmt.Sprintf("%.2f", float32(memory.Slab)/1024/1024),
+			"sreclaimable":    fmt.Sprintf("%.2f", float32(memory.Sreclaimable)/1024/1024),
+			"sunreclaim":      fmt.Sprintf("%.2f", float32(memory.Sunreclaim)/1024/1024),
+			"dirty":           fmt.Sprintf("%.2f", float32(memory.Dirty)/1024/1024),
+			"writeback":       fmt.Sprintf("%.2f", float32(memory.WriteBack)/1024/1024),
+			"hugepages_total": memory.HugePagesTotal,
+			"hugepages_free":  memory.HugePagesFree,
+			"hugepage_size":   fmt.Sprintf("%.2f", float32(memory.HugePageSize)/1024/1024),
+		},
+		"Paging": getPaging(),
 	}
 
 	data, _ := json.Marshal(info)
@@ -364,3 +386,25 @@ func getMemInfo() string {
 	logMessage(DEBUG, string(data))
 	return string(data)
 }
+
+func getPaging() map[string]uint64 {
+	// Get paging activity since the former report from /proc/vmstat
+	counters := readFlatKeyed(filepath.Join(PROCFS_PATH, "vmstat"))
+	if counters == nil {
+		return map[string]uint64{}
+	}
+
+	if VMSTAT_FORMER == nil {
+		VMSTAT_FORMER = counters
+	}
+
+	paging := map[string]uint64{}
+	for _, key := range []string{"pgfault", "pgmajfault", "pgpgin", "pgpgout", "pswpin", "pswpout", "oom_kill"} {
+		if _, ok := counters[key]; ok {
+			paging[key] = deltaUint64(counters[key], VMSTAT_FORMER[key])
+		}
+	}
+
+	VMSTAT_FORMER = counters
+	return paging
+}
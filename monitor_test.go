@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetPaging(t *testing.T) {
+	root := setProcfsPath(t)
+	oldFormer := VMSTAT_FORMER
+	VMSTAT_FORMER = nil
+	t.Cleanup(func() { VMSTAT_FORMER = oldFormer })
+
+	if got := getPaging(); len(got) != 0 {
+		t.Errorf("getPaging() = %v without procfs, want none", got)
+	}
+
+	// oom_kill is missing on kernels before 4.13
+	path := filepath.Join(root, "vmstat")
+	writeFixture(t, path, "nr_free_pages 1000\npgpgin 500\npgpgout 800\npswpin 0\npswpout 0\npgfault 10000\npgmajfault 20\n")
+	want := map[string]uint64{"pgfault": 0, "pgmajfault": 0, "pgpgin": 0, "pgpgout": 0, "pswpin": 0, "pswpout": 0}
+	if got := getPaging(); !reflect.DeepEqual(got, want) {
+		t.Errorf("getPaging() = %v on the first report, want %v", got, want)
+	}
+
+	writeFixture(t, path, "nr_free_pages 900\npgpgin 700\npgpgout 800\npswpin 3\npswpout 5\npgfault 12500\npgmajfault 25\noom_kill 1\n")
+	want = map[string]uint64{"pgfault": 2500, "pgmajfault": 5, "pgpgin": 200, "pgpgout": 0, "pswpin": 3, "pswpout": 5, "oom_kill": 1}
+	if got := getPaging(); !reflect.DeepEqual(got, want) {
+		t.Errorf("getPaging() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var NETSTAT_FORMER map[string]uint64 = nil
+
+var NETSTAT_COUNTERS = map[string][]string{
+	"Tcp":    {"RetransSegs", "OutRsts", "EstabResets", "AttemptFails", "InErrs"},
+	"TcpExt": {"ListenOverflows", "ListenDrops", "TCPTimeouts", "TCPSynRetrans"},
+	"Udp":    {"InErrors", "RcvbufErrors", "SndbufErrors", "NoPorts"},
+}
+
+// Parses the header/value line pairs of /proc/net/snmp and /proc/net/netstat
+// into "<Proto>.<Field>" keys.
+func readProcNetStat(path string, counters map[string]uint64) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	lines := strings.Split(string(file), "\n")
+	for i := 0; i+1 < len(lines); i += 2 {
+		header := strings.Fields(lines[i])
+		values := strings.Fields(lines[i+1])
+		if len(header) == 0 || len(header) != len(values) || header[0] != values[0] {
+			continue
+		}
+
+		proto := strings.TrimSuffix(header[0], ":")
+		for j := 1; j < len(header); j++ {
+			value, err := strconv.ParseUint(values[j], 10, 64)
+			if err != nil {
+				continue
+			}
+			counters[proto+"."+header[j]] = value
+		}
+	}
+}
+
+// Sums the per CPU hex columns of /proc/net/softnet_stat
+func readSoftnetStat(path string, counters map[string]uint64) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(file), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		processed, _ := strconv.ParseUint(fields[0], 16, 64)
+		dropped, _ := strconv.ParseUint(fields[1], 16, 64)
+		squeezed, _ := strconv.ParseUint(fields[2], 16, 64)
+		counters["Softnet.processed"] += processed
+		counters["Softnet.dropped"] += dropped
+		counters["Softnet.time_squeeze"] += squeezed
+	}
+}
+
+func getConntrack() map[string]interface{} {
+	dir := filepath.Join(PROCFS_PATH, "sys", "net", "netfilter")
+	count, ok := readSingleValue(filepath.Join(dir, "nf_conntrack_count"))
+	if !ok {
+		return nil
+	}
+	limit, _ := readSingleValue(filepath.Join(dir, "nf_conntrack_max"))
+
+	conntrackCount, _ := strconv.ParseUint(count, 10, 64)
+	conntrackMax, _ := strconv.ParseUint(limit, 10, 64)
+	percent := 0.0
+	if conntrackMax > 0 {
+		percent = float64(conntrackCount) / float64(conntrackMax) * 100
+	}
+
+	return map[string]interface{}{
+		"count":   conntrackCount,
+		"max":     conntrackMax,
+		"percent": fmt.Sprintf("%.2f", percent),
+	}
+}
+
+func getNetStack() string {
+	// Get kernel network stack counters since the former report
+	counters := map[string]uint64{}
+	readProcNetStat(filepath.Join(PROCFS_PATH, "net", "snmp"), counters)
+	readProcNetStat(filepath.Join(PROCFS_PATH, "net", "netstat"), counters)
+	readSoftnetStat(filepath.Join(PROCFS_PATH, "net", "softnet_stat"), counters)
+
+	if len(counters) == 0 {
+		return "{}"
+	}
+	if NETSTAT_FORMER == nil {
+		NETSTAT_FORMER = counters
+	}
+
+	stack := map[string]interface{}{}
+	for proto, fields := range NETSTAT_COUNTERS {
+		values := map[string]uint64{}
+		for _, field := range fields {
+			key := proto + "." + field
+			if _, ok := counters[key]; ok {
+				values[field] = deltaUint64(counters[key], NETSTAT_FORMER[key])
+			}
+		}
+		stack[proto] = values
+	}
+	if _, ok := counters["Softnet.processed"]; ok {
+		stack["Softnet"] = map[string]uint64{
+			"dropped":      deltaUint64(counters["Softnet.dropped"], NETSTAT_FORMER["Softnet.dropped"]),
+			"time_squeeze": deltaUint64(counters["Softnet.time_squeeze"], NETSTAT_FORMER["Softnet.time_squeeze"]),
+		}
+	}
+	if conntrack := getConntrack(); conntrack != nil {
+		stack["Conntrack"] = conntrack
+	}
+
+	NETSTAT_FORMER = counters
+	data, _ := json.Marshal(stack)
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const SNMP_FIXTURE = `Ip: Forwarding DefaultTTL InReceives
+Ip: 1 64 1000
+Icmp: InMsgs InErrors
+Icmp: 10 0
+Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens PassiveOpens AttemptFails EstabResets CurrEstab InSegs OutSegs RetransSegs InErrs OutRsts InCsumErrors
+Tcp: 1 200 120000 -1 500 300 7 3 12 90000 80000 40 2 9 0
+Udp: InDatagrams NoPorts InErrors OutDatagrams RcvbufErrors SndbufErrors InCsumErrors IgnoredMulti MemErrors
+Udp: 2000 5 1 2100 1 0 0 0 0
+`
+
+const NETSTAT_FIXTURE = `TcpExt: SyncookiesSent ListenOverflows ListenDrops TCPTimeouts TCPSynRetrans
+TcpExt: 0 4 6 20 8
+IpExt: InNoRoutes InTruncatedPkts
+IpExt: 0 0
+`
+
+func TestReadProcNetStat(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, filepath.Join(dir, "snmp"), SNMP_FIXTURE)
+	// Mismatched pairs are skipped, the pair after them still counts
+	writeFixture(t, filepath.Join(dir, "netstat"), "TcpExt: A B\nIpExt: 1 2\n"+NETSTAT_FIXTURE+"MPTcpExt: MPCapableSYNRX\n")
+
+	counters := map[string]uint64{}
+	readProcNetStat(filepath.Join(dir, "snmp"), counters)
+	readProcNetStat(filepath.Join(dir, "netstat"), counters)
+	readProcNetStat(filepath.Join(dir, "missing"), counters)
+
+	want := map[string]uint64{
+		"Tcp.RetransSegs":    40,
+		"Tcp.OutRsts":        9,
+		"Tcp.EstabResets":    3,
+		"Tcp.AttemptFails":   7,
+		"Tcp.InErrs":         2,
+		"Udp.NoPorts":        5,
+		"Udp.RcvbufErrors":   1,
+		"TcpExt.ListenDrops": 6,
+		"TcpExt.TCPTimeouts": 20,
+		"Ip.InReceives":      1000,
+	}
+	for key, value := range want {
+		if counters[key] != value {
+			t.Errorf("%v = %v, want %v", key, counters[key], value)
+		}
+	}
+	// MaxConn is -1 with a dynamic limit and does not parse as a counter
+	if _, ok := counters["Tcp.MaxConn"]; ok {
+		t.Errorf("Tcp.MaxConn = %v, want it skipped", counters["Tcp.MaxConn"])
+	}
+	if _, ok := counters["TcpExt.A"]; ok {
+		t.Error("mismatched header should be skipped")
+	}
+}
+
+func TestReadSoftnetStat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "softnet_stat")
+	writeFixture(t, path, "0000a000 00000001 0000000f 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000\n"+
+		"00000100 00000000 00000001 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000001\n"+
+		"short\n")
+
+	counters := map[string]uint64{}
+	readSoftnetStat(path, counters)
+	want := map[string]uint64{"Softnet.processed": 0xa100, "Softnet.dropped": 1, "Softnet.time_squeeze": 0x10}
+	if !reflect.DeepEqual(counters, want) {
+		t.Errorf("readSoftnetStat() = %v, want %v", counters, want)
+	}
+
+	counters = map[string]uint64{}
+	readSoftnetStat(filepath.Join(t.TempDir(), "missing"), counters)
+	if len(counters) != 0 {
+		t.Errorf("readSoftnetStat(missing) = %v, want none", counters)
+	}
+}
+
+func TestGetConntrack(t *testing.T) {
+	root := setProcfsPath(t)
+	// The nf_conntrack module is not loaded
+	if got := getConntrack(); got != nil {
+		t.Errorf("getConntrack() = %v without conntrack, want nil", got)
+	}
+
+	dir := filepath.Join(root, "sys", "net", "netfilter")
+	writeFixture(t, filepath.Join(dir, "nf_conntrack_count"), "512\n")
+	if got := getConntrack(); got["count"] != uint64(512) || got["max"] != uint64(0) || got["percent"] != "0.00" {
+		t.Errorf("getConntrack() = %v without a max, want 512 at 0.00", got)
+	}
+
+	writeFixture(t, filepath.Join(dir, "nf_conntrack_max"), "262144\n")
+	want := map[string]interface{}{"count": uint64(512), "max": uint64(262144), "percent": "0.20"}
+	if got := getConntrack(); !reflect.DeepEqual(got, want) {
+		t.Errorf("getConntrack() = %v, want %v", got, want)
+	}
+}
+
+func TestGetNetStack(t *testing.T) {
+	root := setProcfsPath(t)
+	oldFormer := NETSTAT_FORMER
+	NETSTAT_FORMER = nil
+	t.Cleanup(func() { NETSTAT_FORMER = oldFormer })
+
+	if got := getNetStack(); got != "{}" {
+		t.Errorf("getNetStack() = %v without procfs, want {}", got)
+	}
+
+	writeFixture(t, filepath.Join(root, "net", "snmp"), SNMP_FIXTURE)
+	writeFixture(t, filepath.Join(root, "net", "netstat"), NETSTAT_FIXTURE)
+	getNetStack()
+
+	// Counters are reported as the change since the former report
+	writeFixture(t, filepath.Join(root, "net", "netstat"), "TcpExt: ListenOverflows ListenDrops\nTcpExt: 10 6\n")
+	got := getNetStack()
+	want := `{"Tcp":{"AttemptFails":0,"EstabResets":0,"InErrs":0,"OutRsts":0,"RetransSegs":0},` +
+		`"TcpExt":{"ListenDrops":0,"ListenOverflows":6},` +
+		`"Udp":{"InErrors":0,"NoPorts":0,"RcvbufErrors":0,"SndbufErrors":0}}`
+	if got != want {
+		t.Errorf("getNetStack() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Hides the first prefix bits of ip, octets/groups that are fully hidden become "*"
+func maskIPPrefix(ip net.IP, prefix int) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		parts := make([]string, 4)
+		for i, octet := range ip4 {
+			hidden := min(max(prefix-i*8, 0), 8)
+			if hidden == 8 {
+				parts[i] = "*"
+			} else {
+				parts[i] = strconv.Itoa(int(octet & byte(0xff>>hidden)))
+			}
+		}
+		return strings.Join(parts, ".")
+	}
+
+	parts := make([]string, 8)
+	for i := 0; i < 8; i++ {
+		group := uint16(ip[i*2])<<8 | uint16(ip[i*2+1])
+		hidden := min(max(prefix-i*16, 0), 16)
+		if hidden == 16 {
+			parts[i] = "*"
+		} else {
+			parts[i] = strconv.FormatUint(uint64(group&uint16(0xffff>>hidden)), 16)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+func hashIP(ip net.IP) string {
+	mac := hmac.New(sha256.New, []byte(IP_PRIVACY_SALT))
+	mac.Write([]byte(ip.String()))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Applies IP_PRIVACY to an address, "addr/len" keeps its prefix length.
+// Values that are not an IP, unspecified and loopback addresses pass through.
+func maskIP(value string) string {
+	addr, suffix, hasSuffix := strings.Cut(value, "/")
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+		return value
+	}
+
+	masked := addr
+	switch IP_PRIVACY {
+	case "none":
+	case "hash":
+		masked = hashIP(ip)
+	case "omit":
+		return ""
+	default:
+		if ip.To4() != nil {
+			masked = maskIPPrefix(ip, IP_PRIVACY_PREFIX_V4)
+		} else {
+			masked = maskIPPrefix(ip, IP_PRIVACY_PREFIX_V6)
+		}
+	}
+
+	if hasSuffix && IP_PRIVACY != "hash" {
+		return fmt.Sprintf("%v/%v", masked, suffix)
+	}
+	return masked
+}
+
+func maskIPs(values []string) []string {
+	masked := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = maskIP(value); value != "" {
+			masked = append(masked, value)
+		}
+	}
+	return masked
+}
+
+// Anything that could be an IPv4 or IPv6 address, checked with net.ParseIP
+var IP_TEXT_PATTERN = regexp.MustCompile(`[0-9A-Fa-f]*[:.][0-9A-Fa-f:.]*[0-9A-Fa-f]`)
+
+// Applies IP_PRIVACY to every address in free text such as response bodies
+func maskIPText(text string) string {
+	return IP_TEXT_PATTERN.ReplaceAllStringFunc(text, func(match string) string {
+		// A separator in front, as in "ip:192.0.2.1", is not part of the address
+		addr := strings.TrimLeft(match, ":.")
+		if net.ParseIP(addr) == nil {
+			return match
+		}
+		masked := maskIP(addr)
+		if masked == "" {
+			masked = "[ip]"
+		}
+		return match[:len(match)-len(addr)] + masked
+	})
+}
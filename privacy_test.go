@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestMaskIPText(t *testing.T) {
+	oldPrivacy, oldV4, oldV6 := IP_PRIVACY, IP_PRIVACY_PREFIX_V4, IP_PRIVACY_PREFIX_V6
+	t.Cleanup(func() { IP_PRIVACY, IP_PRIVACY_PREFIX_V4, IP_PRIVACY_PREFIX_V6 = oldPrivacy, oldV4, oldV6 })
+	IP_PRIVACY, IP_PRIVACY_PREFIX_V4, IP_PRIVACY_PREFIX_V6 = "partial", 24, 64
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"203.0.113.10\n", "*.*.*.10\n"},
+		{`{"ip":"203.0.113.10","city":"Central"}`, `{"ip":"*.*.*.10","city":"Central"}`},
+		{"ip:203.0.113.10", "ip:*.*.*.10"},
+		{`{"ip":"2001:db8::10"}`, `{"ip":"*:*:*:*:0:0:0:10"}`},
+		// Versions, times and loopback stay as they are
+		{"v1.2.3 at 12:30:00 from 127.0.0.1", "v1.2.3 at 12:30:00 from 127.0.0.1"},
+		{`{"latitude":22.28,"asn":"AS64500"}`, `{"latitude":22.28,"asn":"AS64500"}`},
+	}
+	for _, test := range tests {
+		if got := maskIPText(test.text); got != test.want {
+			t.Errorf("maskIPText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+
+	IP_PRIVACY = "omit"
+	if got := maskIPText(`{"ip":"203.0.113.10"}`); got != `{"ip":"[ip]"}` {
+		t.Errorf("maskIPText() with omit = %q", got)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+type processStat struct {
+	Pid     int32   `json:"pid"`
+	Name    string  `json:"name"`
+	User    string  `json:"user"`
+	Cmdline string  `json:"cmdline"`
+	CPU     string  `json:"cpu"`
+	RSS     string  `json:"rss"`
+	Threads int32   `json:"threads"`
+	FDs     int32   `json:"fds"`
+	State   string  `json:"state"`
+	cpu     float64 // raw cpu percent, used for sorting
+	rss     uint64  // raw rss bytes, used for sorting
+}
+
+var PROCESS_FORMER map[int32]float64 = nil
+var PROCESS_FORMER_TIME time.Time
+
+func parseProcessRedact(pattern string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Invalid PROCESS_REDACT pattern: %v", err))
+		return nil
+	}
+	return re
+}
+
+func redactProcess(stat *processStat) {
+	if PROCESS_REDACT == nil {
+		return
+	}
+	if PROCESS_REDACT.MatchString(stat.Name) || PROCESS_REDACT.MatchString(stat.Cmdline) {
+		stat.Name = "[redacted]"
+		stat.Cmdline = "[redacted]"
+	}
+}
+
+func truncateString(input string, length int) string {
+	if length <= 0 || len([]rune(input)) <= length {
+		return input
+	}
+	return string([]rune(input)[:length]) + "..."
+}
+
+func getProcessDetail(p *process.Process, cpuPercent float64, rss uint64) processStat {
+	name, _ := p.Name()
+	user, _ := p.Username()
+	cmdline, _ := p.Cmdline()
+	threads, _ := p.NumThreads()
+	fds, _ := p.NumFDs()
+	status, _ := p.Status()
+
+	stat := processStat{
+		Pid:     p.Pid,
+		Name:    name,
+		User:    user,
+		Cmdline: truncateString(cmdline, PROCESS_CMDLINE_LEN),
+		CPU:     fmt.Sprintf("%.2f", cpuPercent),
+		RSS:     fmt.Sprintf("%.2f", float32(rss)/1024/1024),
+		Threads: threads,
+		FDs:     fds,
+		State:   strings.Join(status, ","),
+		cpu:     cpuPercent,
+		rss:     rss,
+	}
+	redactProcess(&stat)
+	return stat
+}
+
+func getTopProcess() string {
+	// Get top N processes by CPU and RSS
+	if PROCESS_TOP_N <= 0 {
+		return "{}"
+	}
+
+	processes, err := process.Processes()
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to list processes: %v", err))
+		return "{}"
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(PROCESS_FORMER_TIME).Seconds()
+	current := make(map[int32]float64, len(processes))
+	stats := make([]processStat, 0, len(processes))
+	handles := make(map[int32]*process.Process, len(processes))
+
+	// Only CPU times and RSS are read for every process, the rest is
+	// fetched for the ones that make it into a top list
+	for _, p := range processes {
+		times, err := p.Times()
+		if err != nil {
+			continue
+		}
+		total := times.User + times.System
+		current[p.Pid] = total
+
+		cpuPercent := 0.0
+		if former, ok := PROCESS_FORMER[p.Pid]; ok && elapsed > 0 && total > former {
+			cpuPercent = (total - former) / elapsed * 100
+		}
+
+		var rss uint64
+		if memory, err := p.MemoryInfo(); err == nil {
+			rss = memory.RSS
+		}
+
+		handles[p.Pid] = p
+		stats = append(stats, processStat{Pid: p.Pid, cpu: cpuPercent, rss: rss})
+	}
+
+	PROCESS_FORMER = current
+	PROCESS_FORMER_TIME = now
+
+	n := PROCESS_TOP_N
+	if n > len(stats) {
+		n = len(stats)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].cpu > stats[j].cpu })
+	byCPU := append([]processStat(nil), stats[:n]...)
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].rss > stats[j].rss })
+	byMemory := append([]processStat(nil), stats[:n]...)
+
+	details := map[int32]processStat{}
+	for _, top := range [][]processStat{byCPU, byMemory} {
+		for i, stat := range top {
+			detail, ok := details[stat.Pid]
+			if !ok {
+				detail = getProcessDetail(handles[stat.Pid], stat.cpu, stat.rss)
+				details[stat.Pid] = detail
+			}
+			top[i] = detail
+		}
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"cpu":    byCPU,
+		"memory": byMemory,
+	})
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
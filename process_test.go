@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestRedactProcess(t *testing.T) {
+	if parseProcessRedact("") != nil {
+		t.Error("parseProcessRedact() = pattern, want nil")
+	}
+	if parseProcessRedact("([") != nil {
+		t.Error("parseProcessRedact(invalid) = pattern, want nil")
+	}
+
+	oldRedact := PROCESS_REDACT
+	PROCESS_REDACT = parseProcessRedact("--password|secretd")
+	t.Cleanup(func() { PROCESS_REDACT = oldRedact })
+
+	tests := []struct {
+		name, cmdline string
+		redacted      bool
+	}{
+		{"mysql", "mysql --password=hunter2", true},
+		{"secretd", "/usr/bin/secretd", true},
+		{"nginx", "nginx -g daemon off;", false},
+	}
+	for _, test := range tests {
+		stat := processStat{Name: test.name, Cmdline: test.cmdline}
+		redactProcess(&stat)
+		if got := stat.Name == "[redacted]" && stat.Cmdline == "[redacted]"; got != test.redacted {
+			t.Errorf("redactProcess(%v) = %v, want redacted %v", test.cmdline, stat, test.redacted)
+		}
+	}
+}
+
+func TestGetTopProcess(t *testing.T) {
+	oldTopN, oldFormer := PROCESS_TOP_N, PROCESS_FORMER
+	PROCESS_TOP_N, PROCESS_FORMER = 3, nil
+	t.Cleanup(func() { PROCESS_TOP_N, PROCESS_FORMER = oldTopN, oldFormer })
+
+	getTopProcess()
+	var top map[string][]processStat
+	if err := json.Unmarshal([]byte(getTopProcess()), &top); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, list := range []string{"cpu", "memory"} {
+		if len(top[list]) == 0 || len(top[list]) > 3 {
+			t.Fatalf("%v = %v, want 1 to 3 processes", list, top[list])
+		}
+		for _, stat := range top[list] {
+			// Details are only fetched for listed processes, they must be there
+			if stat.Pid == 0 || stat.Name == "" || stat.CPU == "" || stat.RSS == "" {
+				t.Errorf("%v entry = %+v, want details", list, stat)
+			}
+		}
+	}
+	for i := 1; i < len(top["memory"]); i++ {
+		previous, _ := strconv.ParseFloat(top["memory"][i-1].RSS, 64)
+		rss, _ := strconv.ParseFloat(top["memory"][i].RSS, 64)
+		if rss > previous {
+			t.Errorf("memory = %v, want sorted by RSS", top["memory"])
+		}
+	}
+}
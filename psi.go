@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var PSI_RESOURCES = []string{"cpu", "memory", "io"}
+var PSI_FORMER map[string]uint64 = nil
+
+// Parses "some avg10=0.00 avg60=0.00 avg300=0.00 total=0" lines.
+// Totals are returned separately, keyed by "<prefix>.<some|full>".
+func readPressure(path, prefix string, totals map[string]uint64) map[string]interface{} {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	pressure := map[string]interface{}{}
+	for _, line := range strings.Split(string(file), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		kind := fields[0]
+		avgs := map[string]interface{}{}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			if key == "total" {
+				totals[prefix+"."+kind], _ = strconv.ParseUint(value, 10, 64)
+				continue
+			}
+			avgs[key] = value
+		}
+		pressure[kind] = avgs
+	}
+	return pressure
+}
+
+// Reads <dir>/<resource><suffix> for every PSI resource and replaces totals
+// with the stall time (usec) since the former sample.
+func readPressureDir(dir, suffix string, former, current map[string]uint64) map[string]interface{} {
+	pressures := map[string]interface{}{}
+	for _, resource := range PSI_RESOURCES {
+		pressure := readPressure(filepath.Join(dir, resource+suffix), resource, current)
+		if pressure == nil {
+			continue
+		}
+		for kind, avgs := range pressure {
+			key := resource + "." + kind
+			total, ok := former[key]
+			if !ok {
+				total = current[key]
+			}
+			avgs.(map[string]interface{})["total"] = deltaUint64(current[key], total)
+		}
+		pressures[resource] = pressure
+	}
+	return pressures
+}
+
+func getPressure() string {
+	// Get pressure stall information, omitted on kernels without PSI
+	current := map[string]uint64{}
+	pressures := readPressureDir(filepath.Join(PROCFS_PATH, "pressure"), "", PSI_FORMER, current)
+	PSI_FORMER = current
+
+	data, _ := json.Marshal(pressures)
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadPressure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory")
+	writeFixture(t, path, "some avg10=1.50 avg60=0.75 avg300=0.20 total=12345\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=678\n\n")
+
+	totals := map[string]uint64{}
+	got := readPressure(path, "memory", totals)
+	want := map[string]interface{}{
+		"some": map[string]interface{}{"avg10": "1.50", "avg60": "0.75", "avg300": "0.20"},
+		"full": map[string]interface{}{"avg10": "0.00", "avg60": "0.00", "avg300": "0.00"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readPressure() = %v, want %v", got, want)
+	}
+	if wantTotals := map[string]uint64{"memory.some": 12345, "memory.full": 678}; !reflect.DeepEqual(totals, wantTotals) {
+		t.Errorf("totals = %v, want %v", totals, wantTotals)
+	}
+
+	if got := readPressure(filepath.Join(t.TempDir(), "missing"), "memory", totals); got != nil {
+		t.Errorf("readPressure(missing) = %v, want nil", got)
+	}
+}
+
+func TestReadPressureDir(t *testing.T) {
+	root := setProcfsPath(t)
+	oldFormer := PSI_FORMER
+	PSI_FORMER = nil
+	t.Cleanup(func() { PSI_FORMER = oldFormer })
+
+	// Kernels without PSI have no pressure directory
+	if got := getPressure(); got != "{}" {
+		t.Errorf("getPressure() = %v without PSI, want {}", got)
+	}
+
+	// The cpu file of older kernels has no full line, io is missing here
+	dir := filepath.Join(root, "pressure")
+	writeFixture(t, filepath.Join(dir, "cpu"), "some avg10=2.00 avg60=1.00 avg300=0.50 total=1000\n")
+	writeFixture(t, filepath.Join(dir, "memory"), "some avg10=0.00 avg60=0.00 avg300=0.00 total=400\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=300\n")
+
+	tests := []struct {
+		cpu, memory string
+		want        map[string]uint64
+	}{
+		// Nothing to compare against on the first sample
+		{"", "", map[string]uint64{"cpu.some": 0, "memory.some": 0, "memory.full": 0}},
+		{"total=1600", "total=900", map[string]uint64{"cpu.some": 600, "memory.some": 500, "memory.full": 0}},
+		// A total going back, e.g. after a reset, counts as no stall
+		{"total=100", "total=900", map[string]uint64{"cpu.some": 0, "memory.some": 0, "memory.full": 0}},
+	}
+	for i, test := range tests {
+		if test.cpu != "" {
+			writeFixture(t, filepath.Join(dir, "cpu"), "some avg10=2.00 avg60=1.00 avg300=0.50 "+test.cpu+"\n")
+			writeFixture(t, filepath.Join(dir, "memory"), "some avg10=0.00 avg60=0.00 avg300=0.00 "+test.memory+"\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=300\n")
+		}
+
+		current := map[string]uint64{}
+		pressures := readPressureDir(dir, "", PSI_FORMER, current)
+		PSI_FORMER = current
+
+		if _, ok := pressures["io"]; ok || len(pressures) != 2 {
+			t.Errorf("sample %v: pressures = %v, want cpu and memory", i, pressures)
+		}
+		got := map[string]uint64{}
+		for resource, pressure := range pressures {
+			for kind, avgs := range pressure.(map[string]interface{}) {
+				got[resource+"."+kind] = avgs.(map[string]interface{})["total"].(uint64)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sample %v: totals = %v, want %v", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const REDIS_CLUSTER_SLOTS = 16384
+
+// Bump when the key layout changes and add the step to REDIS_MIGRATIONS
+const REDIS_SCHEMA_VERSION = 2
+
+var REDIS_POOL *redis.Pool = nil
+
+var REDIS_TLS_CONFIG *tls.Config = nil
+
+// Settings taken from REDIS_URL, keyed by the variable they default
+var REDIS_URL_OPTIONS = map[string]string{}
+
+// Cluster mode keeps one pool per node and the slot -> node address table
+var (
+	REDIS_CLUSTER_POOLS = map[string]*redis.Pool{}
+	REDIS_CLUSTER_TABLE []string
+	REDIS_CLUSTER_LOCK  sync.Mutex
+)
+
+// REDIS_URL format: redis[s]://[[username]:password@]host[:port][/db]
+func parseRedisURL(raw string) (map[string]string, error) {
+	options := map[string]string{}
+	if raw == "" {
+		return options, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		options["SSL"] = "false"
+	case "rediss":
+		options["SSL"] = "true"
+	default:
+		return nil, fmt.Errorf("unknown scheme %v", u.Scheme)
+	}
+
+	if host := u.Hostname(); host != "" {
+		options["HOST"] = host
+	}
+	if port := u.Port(); port != "" {
+		options["PORT"] = port
+	}
+	if u.User != nil {
+		if username := u.User.Username(); username != "" {
+			options["REDIS_USERNAME"] = username
+		}
+		if password, ok := u.User.Password(); ok {
+			options["PASSWORD"] = password
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %v", db)
+		}
+		options["REDIS_DB"] = db
+	}
+	return options, nil
+}
+
+// Where each Redis setting came from, logged once the logger is up
+var REDIS_SETTING_SOURCES = map[string]string{}
+
+// Explicit settings take precedence over REDIS_URL
+func getRedisEnv(key, defaultValue string) string {
+	if value := getEnv(key, ""); value != "" {
+		REDIS_SETTING_SOURCES[key] = "env"
+		return value
+	}
+	if value, ok := REDIS_URL_OPTIONS[key]; ok {
+		REDIS_SETTING_SOURCES[key] = "REDIS_URL"
+		return value
+	}
+	REDIS_SETTING_SOURCES[key] = "default"
+	return defaultValue
+}
+
+func logRedisSettingSources() {
+	keys := make([]string, 0, len(REDIS_SETTING_SOURCES))
+	for key := range REDIS_SETTING_SOURCES {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sources := make([]string, 0, len(keys))
+	for _, key := range keys {
+		sources = append(sources, fmt.Sprintf("%v from %v", key, REDIS_SETTING_SOURCES[key]))
+	}
+	logMessage(INFO, fmt.Sprintf("Redis settings: %v", strings.Join(sources, ", ")))
+}
+
+func getRedisTLSConfig() (*tls.Config, error) {
+	if REDIS_TLS_CONFIG != nil {
+		return REDIS_TLS_CONFIG, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         REDIS_TLS_SERVER_NAME,
+		InsecureSkipVerify: REDIS_TLS_SKIP_VERIFY,
+	}
+	if REDIS_TLS_CA != "" {
+		pem, err := os.ReadFile(REDIS_TLS_CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", REDIS_TLS_CA)
+		}
+	}
+	if REDIS_TLS_CERT != "" {
+		cert, err := tls.LoadX509KeyPair(REDIS_TLS_CERT, REDIS_TLS_KEY)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	REDIS_TLS_CONFIG = config
+	return config, nil
+}
+
+func dialRedisAddr(addr string) (redis.Conn, error) {
+	timeout := time.Duration(SOCKET_TIMEOUT) * time.Second
+	options := []redis.DialOption{
+		redis.DialUseTLS(SSL),
+		redis.DialConnectTimeout(timeout),
+		redis.DialReadTimeout(timeout),
+		redis.DialWriteTimeout(timeout),
+		redis.DialUsername(REDIS_USERNAME),
+		redis.DialPassword(PASSWORD),
+		redis.DialDatabase(REDIS_DB),
+	}
+	if SSL {
+		tlsConfig, err := getRedisTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("fail to load TLS config: %v", err)
+		}
+		options = append(options, redis.DialTLSConfig(tlsConfig))
+	}
+	return redis.Dial("tcp", addr, options...)
+}
+
+func getSentinelMaster() (string, error) {
+	timeout := time.Duration(SOCKET_TIMEOUT) * time.Second
+	var lastErr error = errors.New("no sentinel configured")
+
+	for _, sentinel := range REDIS_SENTINELS {
+		conn, err := redis.Dial(
+			"tcp",
+			sentinel,
+			redis.DialConnectTimeout(timeout),
+			redis.DialReadTimeout(timeout),
+			redis.DialWriteTimeout(timeout),
+			redis.DialPassword(SENTINEL_PASSWORD),
+		)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addr, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", REDIS_MASTER))
+		conn.Close()
+		if err != nil || len(addr) != 2 {
+			lastErr = fmt.Errorf("sentinel %v has no master %v: %v", sentinel, REDIS_MASTER, err)
+			continue
+		}
+		return fmt.Sprintf("%v:%v", addr[0], addr[1]), nil
+	}
+	return "", lastErr
+}
+
+func dialRedis() (redis.Conn, error) {
+	if REDIS_MODE != "sentinel" {
+		return dialRedisAddr(fmt.Sprintf("%v:%v", HOST, PORT))
+	}
+
+	addr, err := getSentinelMaster()
+	if err != nil {
+		return nil, err
+	}
+	logMessage(DEBUG, fmt.Sprintf("Redis master %v is at %v", REDIS_MASTER, addr))
+	return dialRedisAddr(addr)
+}
+
+func newRedisPool(dial func() (redis.Conn, error)) *redis.Pool {
+	return &redis.Pool{
+		Dial:        dial,
+		MaxIdle:     REDIS_MAX_IDLE,
+		IdleTimeout: time.Duration(REDIS_IDLE_TIMEOUT) * time.Second,
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			// Connections used within the last second are assumed healthy
+			if time.Since(lastUsed) < time.Second {
+				return nil
+			}
+			if REDIS_MODE != "sentinel" {
+				_, err := conn.Do("PING")
+				return err
+			}
+
+			// After a failover the old master comes back as a replica
+			role, err := redis.Values(conn.Do("ROLE"))
+			if err != nil {
+				return err
+			}
+			if len(role) == 0 || fmt.Sprintf("%s", role[0]) != "master" {
+				return errors.New("redis server is no longer master")
+			}
+			return nil
+		},
+	}
+}
+
+func getRedisPool() *redis.Pool {
+	if REDIS_POOL == nil {
+		REDIS_POOL = newRedisPool(dialRedis)
+	}
+	return REDIS_POOL
+}
+
+// CRC16/XMODEM as used by Redis Cluster key hashing
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func getKeySlot(key string) int {
+	// Only the part inside the first non-empty {...} is hashed
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % REDIS_CLUSTER_SLOTS)
+}
+
+// Maps every slot to the master address in a CLUSTER SLOTS reply from node
+func buildClusterTable(node string, slots []interface{}) []string {
+	// Each entry is [start, end, [host, port, id], replicas...]
+	table := make([]string, REDIS_CLUSTER_SLOTS)
+	for _, slot := range slots {
+		entry, err := redis.Values(slot, nil)
+		if err != nil || len(entry) < 3 {
+			continue
+		}
+		start, _ := redis.Int(entry[0], nil)
+		end, _ := redis.Int(entry[1], nil)
+		master, err := redis.Values(entry[2], nil)
+		if err != nil || len(master) < 2 {
+			continue
+		}
+		host, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		if host == "" {
+			// An empty host means the node we asked
+			host = node[:strings.LastIndex(node, ":")]
+		}
+		for i := max(start, 0); i <= end && i < REDIS_CLUSTER_SLOTS; i++ {
+			table[i] = fmt.Sprintf("%v:%v", host, port)
+		}
+	}
+	return table
+}
+
+func refreshClusterSlots() error {
+	REDIS_CLUSTER_LOCK.Lock()
+	defer REDIS_CLUSTER_LOCK.Unlock()
+
+	var lastErr error = errors.New("no cluster node configured")
+	for _, node := range REDIS_CLUSTER_NODES {
+		conn, err := dialRedisAddr(node)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		slots, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		REDIS_CLUSTER_TABLE = buildClusterTable(node, slots)
+		return nil
+	}
+	return lastErr
+}
+
+func getClusterPool(key string) (*redis.Pool, error) {
+	if REDIS_CLUSTER_TABLE == nil {
+		if err := refreshClusterSlots(); err != nil {
+			return nil, err
+		}
+	}
+
+	REDIS_CLUSTER_LOCK.Lock()
+	defer REDIS_CLUSTER_LOCK.Unlock()
+
+	addr := REDIS_CLUSTER_TABLE[getKeySlot(key)]
+	if addr == "" {
+		return nil, fmt.Errorf("slot of %v is not served", key)
+	}
+	pool, ok := REDIS_CLUSTER_POOLS[addr]
+	if !ok {
+		pool = newRedisPool(func() (redis.Conn, error) { return dialRedisAddr(addr) })
+		REDIS_CLUSTER_POOLS[addr] = pool
+	}
+	return pool, nil
+}
+
+func getRedisConn(key string) redis.Conn {
+	// Borrow a connection from the pool, callers must Close it to return it.
+	// In cluster mode the connection goes to the node serving key.
+	pool := getRedisPool()
+	if REDIS_MODE == "cluster" {
+		var err error
+		pool, err = getClusterPool(key)
+		if err != nil {
+			logMessage(ERROR, fmt.Sprintf("Error getting Redis cluster slots: %v", err))
+			return nil
+		}
+	}
+
+	conn := pool.Get()
+	if err := conn.Err(); err != nil {
+		logMessage(ERROR, fmt.Sprintf("Error connecting to Redis: %v", err))
+		conn.Close()
+		return nil
+	}
+
+	return conn
+}
+
+func closeRedis() {
+	if REDIS_POOL != nil {
+		REDIS_POOL.Close()
+	}
+	for _, pool := range REDIS_CLUSTER_POOLS {
+		pool.Close()
+	}
+}
+
+// Migrations keyed by the schema version they upgrade from. Schema 2 only
+// added keys, the legacy hashes of schema 1 follow REDIS_LEGACY_HASHES.
+var REDIS_MIGRATIONS = map[int]func() error{}
+
+var REDIS_SCHEMA_CHECKED = false
+
+// Shared keys are tagged with the prefix in cluster mode so they land on one node
+func getRedisKey(name string) string {
+	if REDIS_MODE == "cluster" {
+		return fmt.Sprintf("{%v}:%v", REDIS_PREFIX, name)
+	}
+	return fmt.Sprintf("%v:%v", REDIS_PREFIX, name)
+}
+
+// Per host keys share a {uuid} hash tag in cluster mode so they can be
+// written in one transaction on the same node.
+func getRedisHostKey(kind string) string {
+	return getRedisHostKeyOf(kind, UUID)
+}
+
+func getRedisHostKeyOf(kind, uuid string) string {
+	if REDIS_MODE == "cluster" {
+		return fmt.Sprintf("%v:%v:{%v}", REDIS_PREFIX, kind, uuid)
+	}
+	return fmt.Sprintf("%v:%v:%v", REDIS_PREFIX, kind, uuid)
+}
+
+func execRedis(key string, commands func(conn redis.Conn)) (interface{}, error) {
+	conn := getRedisConn(key)
+	if conn == nil {
+		return nil, errors.New("fail to connect to Redis")
+	}
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	commands(conn)
+	return conn.Do("EXEC")
+}
+
+func getRedisHGet(key, field string) (int, error) {
+	conn := getRedisConn(key)
+	if conn == nil {
+		return 0, errors.New("fail to connect to Redis")
+	}
+	defer conn.Close()
+	return redis.Int(conn.Do("HGET", key, field))
+}
+
+// Schema 1 kept the IP and schema of every host in the shared hashes and
+// schema hashes, which expired as a whole. The host registry replaces both,
+// they are still written while REDIS_LEGACY_HASHES is on. Agents on legacy
+// keep the hashes alive, so a host that opted out removes its own entries.
+func dropLegacyHashes() error {
+	_, err := execRedis(getRedisKey("hashes"), func(conn redis.Conn) {
+		conn.Send("HDEL", getRedisKey("hashes"), UUID)
+		conn.Send("HDEL", getRedisKey("schema"), UUID)
+	})
+	return err
+}
+
+// Runs the migrations between the schema version this host last wrote and
+// REDIS_SCHEMA_VERSION, and drops the legacy hash entries if they are off,
+// once per process.
+func migrateRedis() error {
+	version, err := getRedisHGet(getRedisHostKey("host"), "schema")
+	if err == redis.ErrNil {
+		// Written before the host registry, or never
+		version, err = getRedisHGet(getRedisKey("schema"), UUID)
+	}
+	if err == redis.ErrNil {
+		version = 0
+	} else if err != nil {
+		return err
+	}
+
+	for ; version < REDIS_SCHEMA_VERSION; version++ {
+		migrate, ok := REDIS_MIGRATIONS[version]
+		if !ok {
+			continue
+		}
+		logMessage(INFO, fmt.Sprintf("Migrating Redis keys from schema %v to %v", version, version+1))
+		if err := migrate(); err != nil {
+			return fmt.Errorf("fail to migrate schema %v: %v", version, err)
+		}
+	}
+
+	if !REDIS_LEGACY_HASHES {
+		if err := dropLegacyHashes(); err != nil {
+			return fmt.Errorf("fail to drop legacy hashes: %v", err)
+		}
+	}
+
+	REDIS_SCHEMA_CHECKED = true
+	return nil
+}
+
+// Writes the keys passed for each role in ARGV[1], so cluster mode can run it
+// once for the host keys and once for the shared keys on their own nodes.
+const REDIS_REPORT_LUA = `
+local keys = {}
+local i = 1
+for role in string.gmatch(ARGV[1], '[^,]+') do
+	keys[role] = KEYS[i]
+	i = i + 1
+end
+
+local now = tonumber(ARGV[2])
+local retention = tonumber(ARGV[3])
+local alive = tonumber(ARGV[4])
+local maxlen = tonumber(ARGV[5])
+local uuid = ARGV[6]
+local data = ARGV[7]
+if not now or not retention or retention <= 0 or not alive or alive <= 0 or not maxlen then
+	return redis.error_reply('ERR invalid time arguments')
+end
+if uuid == '' then
+	return redis.error_reply('ERR empty uuid')
+end
+if not pcall(cjson.decode, data) then
+	return redis.error_reply('ERR sample is not valid JSON')
+end
+local ok, info = pcall(cjson.decode, ARGV[8])
+if not ok or type(info) ~= 'table' then
+	return redis.error_reply('ERR info is not a JSON object')
+end
+local ok, host = pcall(cjson.decode, ARGV[9])
+if not ok or type(host) ~= 'table' then
+	return redis.error_reply('ERR host is not a JSON object')
+end
+local ok, messages = pcall(cjson.decode, ARGV[10])
+if not ok or type(messages) ~= 'table' then
+	return redis.error_reply('ERR messages is not a JSON array')
+end
+local ok, rollups = pcall(cjson.decode, ARGV[11])
+if not ok or type(rollups) ~= 'table' then
+	return redis.error_reply('ERR rollups is not a JSON object')
+end
+-- Only the janitor relies on the data timeout, never fail the report over it
+local registry = retention
+local timeout = tonumber(ARGV[12])
+if timeout and timeout > 0 then
+	registry = math.max(retention, timeout * 2)
+end
+
+local status = {status = 'ok', fields = 0, trimmed = 0, published = 0, rollups = 0}
+local function hset(key, fields)
+	local args = {}
+	for field, value in pairs(fields) do
+		table.insert(args, field)
+		table.insert(args, tostring(value))
+	end
+	if #args > 0 then
+		redis.call('HSET', key, unpack(args))
+	end
+	redis.call('EXPIRE', key, retention)
+	return #args / 2
+end
+-- Stream IDs are in milliseconds, ~ lets Redis trim whole nodes only
+local function xadd(key)
+	local id = redis.call('XADD', key, 'MINID', '~', (now - retention) * 1000, '*', 'uuid', uuid, 'data', data)
+	if maxlen > 0 then
+		status.trimmed = status.trimmed + redis.call('XTRIM', key, 'MAXLEN', '~', maxlen)
+	end
+	redis.call('EXPIRE', key, retention)
+	return id
+end
+local function zadd(key, member, score, ttl)
+	redis.call('ZADD', key, score, member)
+	status.trimmed = status.trimmed + redis.call('ZREMRANGEBYSCORE', key, 0, now - ttl)
+	redis.call('EXPIRE', key, ttl)
+end
+
+if keys.info then
+	status.fields = hset(keys.info, info)
+end
+if keys.host then
+	hset(keys.host, host)
+end
+if keys.collection then
+	zadd(keys.collection, data, now, retention)
+end
+if keys.stream then
+	status.id = xadd(keys.stream)
+end
+if keys.alive then
+	redis.call('SET', keys.alive, '1', 'EX', alive)
+end
+if keys.hosts then
+	-- Hosts stay listed past the data timeout so a janitor can clean up after them
+	zadd(keys.hosts, uuid, now, registry)
+end
+if keys.fleet then
+	xadd(keys.fleet)
+end
+-- Schema 1 layout, kept for servers that do not read the registry yet
+if keys.hashes then
+	redis.call('HSET', keys.hashes, uuid, tostring(host.ip or ''))
+	redis.call('EXPIRE', keys.hashes, retention)
+end
+if keys.schema then
+	redis.call('HSET', keys.schema, uuid, tostring(host.schema or ''))
+	redis.call('EXPIRE', keys.schema, retention)
+end
+-- Rollups keep their own retention
+for role, rollup in pairs(rollups) do
+	if keys[role] then
+		for _, point in ipairs(rollup.points) do
+			zadd(keys[role], point.data, point.time, rollup.retention)
+			status.rollups = status.rollups + 1
+		end
+	end
+end
+for _, message in ipairs(messages) do
+	redis.call('PUBLISH', message[1], message[2])
+	status.published = status.published + 1
+end
+return cjson.encode(status)
+`
+
+// Do sends EVALSHA and falls back to EVAL on NOSCRIPT, which caches the
+// script on that server for the next reports.
+var REDIS_REPORT_SCRIPT = redis.NewScript(-1, REDIS_REPORT_LUA)
+
+type redisReport struct {
+	roles []string
+	keys  []string
+}
+
+func (r *redisReport) add(role, key string) {
+	r.roles = append(r.roles, role)
+	r.keys = append(r.keys, key)
+}
+
+func runReportScript(report redisReport, argv ...interface{}) (string, error) {
+	conn := getRedisConn(report.keys[0])
+	if conn == nil {
+		return "", errors.New("fail to connect to Redis")
+	}
+	defer conn.Close()
+
+	args := redis.Args{len(report.keys)}.AddFlat(report.keys).Add(strings.Join(report.roles, ","))
+	return redis.String(REDIS_REPORT_SCRIPT.Do(conn, append(args, argv...)...))
+}
+
+// Runs the reports not marked done yet. A retry skips the ones that went
+// through, so nothing is added or published twice.
+func runPendingReports(reports []redisReport, done []bool, run func(int, redisReport) error) error {
+	for i, r := range reports {
+		if done[i] {
+			continue
+		}
+		if err := run(i, r); err != nil {
+			return err
+		}
+		done[i] = true
+	}
+	return nil
+}
+
+func reportRedis(info map[string]interface{}, jsonAggregateStat string) error {
+	now := time.Now().Unix()
+
+	if !REDIS_SCHEMA_CHECKED {
+		if err := migrateRedis(); err != nil {
+			return err
+		}
+	}
+
+	// Hash fields hold nested JSON as is and everything else as text
+	infoFields := map[string]string{}
+	for key, value := range info {
+		if raw, ok := value.(json.RawMessage); ok {
+			infoFields[key] = string(raw)
+		} else {
+			infoFields[key] = fmt.Sprint(value)
+		}
+	}
+	jsonInfoFields, _ := json.Marshal(infoFields)
+
+	// The schema lets the server spot agents writing an older layout
+	jsonHost, _ := json.Marshal(map[string]string{
+		"ip":        maskIP(IPV4),
+		"schema":    strconv.Itoa(REDIS_SCHEMA_VERSION),
+		"storage":   REDIS_STORAGE,
+		"last_seen": strconv.FormatInt(now, 10),
+	})
+
+	messages := [][]string{}
+	if REDIS_PUBLISH {
+		jsonInfo, _ := json.Marshal(info)
+		for _, update := range []struct {
+			kind string
+			data string
+		}{{"info", string(jsonInfo)}, {"sample", jsonAggregateStat}} {
+			message, _ := json.Marshal(map[string]interface{}{
+				"uuid": UUID,
+				"type": update.kind,
+				"time": now,
+				"data": json.RawMessage(update.data),
+			})
+			messages = append(messages,
+				[]string{fmt.Sprintf("%v:channel:%v", REDIS_PREFIX, UUID), string(message)},
+				[]string{fmt.Sprintf("%v:channel", REDIS_PREFIX), string(message)},
+			)
+		}
+	}
+	jsonMessages, _ := json.Marshal(messages)
+
+	addRollupSample(jsonAggregateStat, now)
+	rollups := map[string]interface{}{}
+
+	hostReport := redisReport{}
+	hostReport.add("info", getRedisHostKey("info"))
+	hostReport.add("host", getRedisHostKey("host"))
+	if REDIS_STORAGE == "stream" {
+		hostReport.add("stream", getRedisHostKey("stream"))
+	} else {
+		hostReport.add("collection", getRedisHostKey("collection"))
+	}
+	hostReport.add("alive", getRedisHostKey("alive"))
+	for _, rollup := range ROLLUP_INTERVALS {
+		if len(ROLLUP_PENDING[rollup.interval]) == 0 {
+			continue
+		}
+		role := fmt.Sprintf("rollup:%v", rollup.interval)
+		hostReport.add(role, getRedisHostKey(role))
+		rollups[role] = map[string]interface{}{
+			"retention": rollup.retention,
+			"points":    ROLLUP_PENDING[rollup.interval],
+		}
+	}
+	jsonRollups, _ := json.Marshal(rollups)
+
+	// The registry scores hosts by last seen, so each one ages out on its own
+	sharedReport := redisReport{}
+	sharedReport.add("hosts", getRedisKey("hosts"))
+	if REDIS_STORAGE == "stream" && REDIS_FLEET_STREAM {
+		sharedReport.add("fleet", getRedisKey("stream"))
+	}
+	if REDIS_LEGACY_HASHES {
+		sharedReport.add("hashes", getRedisKey("hashes"))
+		sharedReport.add("schema", getRedisKey("schema"))
+	}
+
+	// The shared keys live in other slots in cluster mode
+	reports := []redisReport{hostReport, sharedReport}
+	if REDIS_MODE != "cluster" {
+		hostReport.roles = append(hostReport.roles, sharedReport.roles...)
+		hostReport.keys = append(hostReport.keys, sharedReport.keys...)
+		reports = []redisReport{hostReport}
+	}
+
+	done := make([]bool, len(reports))
+	run := func(i int, r redisReport) error {
+		// Channels carry no key, publish along with the host keys only
+		published := []byte("[]")
+		if i == 0 {
+			published = jsonMessages
+		}
+		status, err := runReportScript(r,
+			now, RETENTION_TIME, ALIVE_CHECK_TIME, REDIS_STREAM_MAXLEN, UUID,
+			jsonAggregateStat, jsonInfoFields, jsonHost, published, jsonRollups, DATA_TIMEOUT,
+		)
+		if err != nil {
+			return err
+		}
+		logMessage(DEBUG, fmt.Sprintf("Response: %v", status))
+		return nil
+	}
+
+	err := runPendingReports(reports, done, run)
+	if err != nil && REDIS_MODE == "cluster" {
+		// Slots may have moved, reload the table and try once more
+		logMessage(INFO, fmt.Sprintf("Retrying after cluster error: %v", err))
+		if refreshErr := refreshClusterSlots(); refreshErr == nil {
+			err = runPendingReports(reports, done, run)
+		}
+	}
+	if err == nil {
+		ROLLUP_PENDING = map[int64][]rollupPoint{}
+	}
+	return err
+}
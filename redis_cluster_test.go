@@ -0,0 +1,225 @@
+//go:build redis
+
+package main
+
+// Sentinel and Cluster setups built from redis-server processes, see
+// redis_script_test.go
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func setReportGlobals(t *testing.T, mode string) {
+	t.Helper()
+	oldMode, oldPrefix, oldUUID, oldRetention, oldAlive, oldStorage, oldChecked :=
+		REDIS_MODE, REDIS_PREFIX, UUID, RETENTION_TIME, ALIVE_CHECK_TIME, REDIS_STORAGE, REDIS_SCHEMA_CHECKED
+	oldPool, oldNodes, oldTable, oldPools := REDIS_POOL, REDIS_CLUSTER_NODES, REDIS_CLUSTER_TABLE, REDIS_CLUSTER_POOLS
+	t.Cleanup(func() {
+		closeRedis()
+		REDIS_MODE, REDIS_PREFIX, UUID, RETENTION_TIME, ALIVE_CHECK_TIME, REDIS_STORAGE, REDIS_SCHEMA_CHECKED =
+			oldMode, oldPrefix, oldUUID, oldRetention, oldAlive, oldStorage, oldChecked
+		REDIS_POOL, REDIS_CLUSTER_NODES, REDIS_CLUSTER_TABLE, REDIS_CLUSTER_POOLS = oldPool, oldNodes, oldTable, oldPools
+	})
+
+	REDIS_MODE, REDIS_PREFIX, UUID = mode, "monitor", "host-a"
+	RETENTION_TIME, ALIVE_CHECK_TIME, REDIS_STORAGE, REDIS_SCHEMA_CHECKED = 600, 30, "stream", false
+	REDIS_POOL, REDIS_CLUSTER_TABLE, REDIS_CLUSTER_POOLS = nil, nil, map[string]*redis.Pool{}
+}
+
+func getRole(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := redis.Dial("tcp", addr)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	role, err := redis.Values(conn.Do("ROLE"))
+	if err != nil || len(role) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s", role[0])
+}
+
+func TestSentinelFailover(t *testing.T) {
+	master := spawnRedis(t, "--save", "", "--appendonly", "no")
+	_, masterPort, _ := net.SplitHostPort(master)
+	replica := spawnRedis(t, "--save", "", "--appendonly", "no", "--replicaof", "127.0.0.1", masterPort)
+
+	// Sentinel rewrites its config file, it has to be writable
+	config := filepath.Join(t.TempDir(), "sentinel.conf")
+	err := os.WriteFile(config, []byte(fmt.Sprintf("sentinel monitor mymaster 127.0.0.1 %v 1\n"+
+		"sentinel down-after-milliseconds mymaster 1000\n"+
+		"sentinel failover-timeout mymaster 5000\n", masterPort)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sentinel := spawnRedis(t, config, "--sentinel")
+
+	setReportGlobals(t, "sentinel")
+	oldSentinels, oldMaster := REDIS_SENTINELS, REDIS_MASTER
+	t.Cleanup(func() { REDIS_SENTINELS, REDIS_MASTER = oldSentinels, oldMaster })
+	REDIS_SENTINELS, REDIS_MASTER = []string{"127.0.0.1:1", sentinel}, "mymaster"
+
+	if addr, err := getSentinelMaster(); err != nil || addr != master {
+		t.Fatalf("getSentinelMaster() = %v, %v, want %v", addr, err, master)
+	}
+	if err := reportRedis(map[string]interface{}{"Hostname": "a"}, `{"Load":{"idle":"90"}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep a pooled connection to the master across the failover
+	conn := getRedisPool().Get()
+	if _, err := conn.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	sentinelConn, err := redis.Dial("tcp", sentinel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sentinelConn.Close()
+	waitFor(t, 30*time.Second, "the sentinel to find the replica", func() bool {
+		replicas, _ := redis.Values(sentinelConn.Do("SENTINEL", "REPLICAS", "mymaster"))
+		return len(replicas) > 0
+	})
+	if _, err := sentinelConn.Do("SENTINEL", "FAILOVER", "mymaster"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 30*time.Second, "the failover", func() bool {
+		addr, err := getSentinelMaster()
+		return err == nil && addr == replica && getRole(t, master) == "slave"
+	})
+
+	// The pooled connection to the old master fails the role check on borrow
+	pool := getRedisPool()
+	stale, err := redis.Dial("tcp", master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stale.Close()
+	if err := pool.TestOnBorrow(stale, time.Now().Add(-time.Minute)); err == nil {
+		t.Error("TestOnBorrow(old master) = nil, want an error")
+	}
+
+	// New connections follow the sentinel to the promoted replica
+	closeRedis()
+	REDIS_POOL = nil
+	if err := reportRedis(map[string]interface{}{"Hostname": "a"}, `{"Load":{"idle":"90"}}`); err != nil {
+		t.Fatalf("report after failover: %v", err)
+	}
+	promoted, err := redis.Dial("tcp", replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer promoted.Close()
+	if length, _ := redis.Int(promoted.Do("XLEN", getRedisHostKey("stream"))); length != 2 {
+		t.Errorf("stream on new master has %v entries, want 2", length)
+	}
+}
+
+// Starts three masters and splits the slots between them
+func startRedisCluster(t *testing.T) []string {
+	t.Helper()
+	nodes := make([]string, 3)
+	for i := range nodes {
+		nodes[i] = spawnRedis(t, "--save", "", "--appendonly", "no",
+			"--cluster-enabled", "yes", "--cluster-config-file", "nodes.conf")
+	}
+
+	for i, node := range nodes {
+		conn, err := redis.Dial("tcp", node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := redis.Args{"ADDSLOTS"}
+		for slot := i * REDIS_CLUSTER_SLOTS / len(nodes); slot < (i+1)*REDIS_CLUSTER_SLOTS/len(nodes); slot++ {
+			args = args.Add(slot)
+		}
+		if _, err := conn.Do("CLUSTER", args...); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			host, port, _ := net.SplitHostPort(node)
+			first, _ := redis.Dial("tcp", nodes[0])
+			_, err = first.Do("CLUSTER", "MEET", host, port)
+			first.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		conn.Close()
+	}
+
+	waitFor(t, 30*time.Second, "the cluster to settle", func() bool {
+		for _, node := range nodes {
+			conn, err := redis.Dial("tcp", node)
+			if err != nil {
+				return false
+			}
+			info, _ := redis.String(conn.Do("CLUSTER", "INFO"))
+			conn.Close()
+			if !strings.Contains(info, "cluster_state:ok") {
+				return false
+			}
+		}
+		return true
+	})
+	return nodes
+}
+
+func TestClusterReport(t *testing.T) {
+	nodes := startRedisCluster(t)
+	setReportGlobals(t, "cluster")
+	REDIS_CLUSTER_NODES = []string{"127.0.0.1:1", nodes[1]}
+
+	info := map[string]interface{}{"Hostname": "a"}
+	sample := `{"Load":{"idle":"90"}}`
+	if err := reportRedis(info, sample); err != nil {
+		t.Fatal(err)
+	}
+
+	// The table matches what the nodes serve
+	for _, key := range []string{getRedisHostKey("stream"), getRedisKey("hosts")} {
+		conn, err := redis.Dial("tcp", REDIS_CLUSTER_TABLE[getKeySlot(key)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := redis.Int(conn.Do("EXISTS", key)); exists != 1 {
+			t.Errorf("%v is not on the node the table routes it to", key)
+		}
+		conn.Close()
+	}
+
+	// A stale entry for the shared keys gets a MOVED reply after the host
+	// report went through, only the shared report is run again
+	hostNode := REDIS_CLUSTER_TABLE[getKeySlot(getRedisHostKey("stream"))]
+	sharedSlot := getKeySlot(getRedisKey("hosts"))
+	for _, node := range nodes {
+		if node != REDIS_CLUSTER_TABLE[sharedSlot] {
+			REDIS_CLUSTER_TABLE[sharedSlot] = node
+			break
+		}
+	}
+	if err := reportRedis(info, sample); err != nil {
+		t.Fatalf("report with a stale table: %v", err)
+	}
+
+	// Each report ran once, the host stream has one entry per report
+	conn, err := redis.Dial("tcp", hostNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if length, _ := redis.Int(conn.Do("XLEN", getRedisHostKey("stream"))); length != 2 {
+		t.Errorf("host stream has %v entries, want 2", length)
+	}
+}
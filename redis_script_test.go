@@ -0,0 +1,345 @@
+//go:build redis
+
+package main
+
+// Runs the Lua scripts on a real server: go test -tags redis ./...
+// Starts redis-server from PATH, or uses REDIS_TEST_ADDR if set.
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func startRedisServer(t *testing.T) string {
+	t.Helper()
+	if addr := os.Getenv("REDIS_TEST_ADDR"); addr != "" {
+		return addr
+	}
+	return spawnRedis(t, "--save", "", "--appendonly", "no")
+}
+
+func getFreePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// Starts redis-server from PATH on a free port with the extra arguments, a
+// config file given first and --sentinel start a sentinel instead
+func spawnRedis(t *testing.T, args ...string) string {
+	t.Helper()
+	path, err := exec.LookPath("redis-server")
+	if err != nil {
+		t.Skip("redis-server is not installed")
+	}
+
+	port := getFreePort(t)
+	dir := t.TempDir()
+	args = append(args, "--port", fmt.Sprint(port), "--bind", "127.0.0.1", "--dir", dir)
+	cmd := exec.Command(path, args...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	addr := fmt.Sprintf("127.0.0.1:%v", port)
+	for i := 0; i < 50; i++ {
+		if conn, err := redis.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("redis-server did not start on %v", addr)
+	return ""
+}
+
+// Polls check every 100ms until it returns true or timeout passes
+func waitFor(t *testing.T, timeout time.Duration, what string, check func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if check() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %v", what)
+}
+
+func setRedisServer(t *testing.T) redis.Conn {
+	t.Helper()
+	addr := startRedisServer(t)
+
+	oldPool, oldMode, oldPrefix := REDIS_POOL, REDIS_MODE, REDIS_PREFIX
+	REDIS_POOL = newRedisPool(func() (redis.Conn, error) { return redis.Dial("tcp", addr) })
+	// Keys are unique per test, so a shared REDIS_TEST_ADDR needs no flush
+	REDIS_MODE, REDIS_PREFIX = "standalone", fmt.Sprintf("test%v:%v", time.Now().UnixNano(), t.Name())
+	t.Cleanup(func() {
+		REDIS_POOL.Close()
+		REDIS_POOL, REDIS_MODE, REDIS_PREFIX = oldPool, oldMode, oldPrefix
+	})
+
+	conn, err := redis.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+type reportArgs struct {
+	now      int64
+	retain   int
+	alive    int
+	maxlen   int
+	uuid     string
+	data     string
+	info     string
+	host     string
+	messages string
+	rollups  string
+	timeout  interface{}
+}
+
+func defaultReportArgs() reportArgs {
+	return reportArgs{
+		now: time.Now().Unix(), retain: 600, alive: 30, maxlen: 0, uuid: "host-a",
+		data: `{"Load":{"idle":"90"}}`, info: `{"Hostname":"a","Disk":"{\"/\":1}"}`,
+		host: `{"ip":"192.0.2.x","schema":"2","last_seen":"1"}`, messages: `[]`, rollups: `{}`, timeout: 100,
+	}
+}
+
+func (a reportArgs) run(report redisReport) (map[string]interface{}, error) {
+	reply, err := runReportScript(report,
+		a.now, a.retain, a.alive, a.maxlen, a.uuid, a.data, a.info, a.host, a.messages, a.rollups, a.timeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]interface{}{}
+	return status, json.Unmarshal([]byte(reply), &status)
+}
+
+func TestReportScriptCollection(t *testing.T) {
+	conn := setRedisServer(t)
+	args := defaultReportArgs()
+	args.messages = `[["` + REDIS_PREFIX + `:channel","hello"]]`
+	args.rollups = fmt.Sprintf(`{"rollup:60":{"retention":3600,"points":[{"time":%v,"data":"a"},{"time":%v,"data":"b"}]}}`,
+		args.now-120, args.now-60)
+
+	report := redisReport{}
+	for _, kind := range []string{"info", "host", "collection", "alive", "rollup:60"} {
+		report.add(kind, getRedisHostKeyOf(kind, args.uuid))
+	}
+	report.add("hosts", getRedisKey("hosts"))
+	report.add("hashes", getRedisKey("hashes"))
+	report.add("schema", getRedisKey("schema"))
+
+	status, err := args.run(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status["status"] != "ok" || status["fields"] != 2.0 || status["rollups"] != 2.0 || status["published"] != 1.0 {
+		t.Errorf("status = %v, want ok with 2 fields, 2 rollups and 1 message", status)
+	}
+
+	info, _ := redis.StringMap(conn.Do("HGETALL", getRedisHostKeyOf("info", args.uuid)))
+	if info["Hostname"] != "a" || info["Disk"] != `{"/":1}` {
+		t.Errorf("info = %v, want Hostname and nested Disk JSON", info)
+	}
+	if seen, _ := redis.String(conn.Do("HGET", getRedisHostKeyOf("host", args.uuid), "last_seen")); seen != "1" {
+		t.Errorf("host last_seen = %v, want 1", seen)
+	}
+	if samples, _ := redis.Strings(conn.Do("ZRANGE", getRedisHostKeyOf("collection", args.uuid), 0, -1)); len(samples) != 1 || samples[0] != args.data {
+		t.Errorf("collection = %v, want the sample", samples)
+	}
+	if ttl, _ := redis.Int(conn.Do("TTL", getRedisHostKeyOf("alive", args.uuid))); ttl <= 0 || ttl > args.alive {
+		t.Errorf("alive ttl = %v, want up to %v", ttl, args.alive)
+	}
+	if points, _ := redis.Int(conn.Do("ZCARD", getRedisHostKeyOf("rollup:60", args.uuid))); points != 2 {
+		t.Errorf("rollup points = %v, want 2", points)
+	}
+	if score, _ := redis.Int64(conn.Do("ZSCORE", getRedisKey("hosts"), args.uuid)); score != args.now {
+		t.Errorf("hosts score = %v, want %v", score, args.now)
+	}
+	if ip, _ := redis.String(conn.Do("HGET", getRedisKey("hashes"), args.uuid)); ip != "192.0.2.x" {
+		t.Errorf("legacy hashes ip = %v, want 192.0.2.x", ip)
+	}
+	if schema, _ := redis.String(conn.Do("HGET", getRedisKey("schema"), args.uuid)); schema != "2" {
+		t.Errorf("legacy schema = %v, want 2", schema)
+	}
+}
+
+func TestReportScriptStream(t *testing.T) {
+	conn := setRedisServer(t)
+	args := defaultReportArgs()
+	args.maxlen = 1
+
+	report := redisReport{}
+	report.add("stream", getRedisHostKeyOf("stream", args.uuid))
+	report.add("fleet", getRedisKey("stream"))
+
+	status, err := args.run(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := status["id"].(string); !strings.Contains(id, "-") {
+		t.Errorf("status id = %v, want a stream ID", status["id"])
+	}
+	for _, key := range []string{getRedisHostKeyOf("stream", args.uuid), getRedisKey("stream")} {
+		if length, _ := redis.Int(conn.Do("XLEN", key)); length != 1 {
+			t.Errorf("XLEN %v = %v, want 1", key, length)
+		}
+	}
+}
+
+func TestReportScriptRegistryTTL(t *testing.T) {
+	conn := setRedisServer(t)
+
+	// The registry outlives a long data timeout, and falls back to the
+	// retention when the timeout is unset or invalid
+	tests := []struct {
+		timeout interface{}
+		want    int
+	}{
+		{100, 600},
+		{1000, 2000},
+		{0, 600},
+		{-5, 600},
+		{"abc", 600},
+	}
+	for _, test := range tests {
+		args := defaultReportArgs()
+		args.timeout = test.timeout
+		report := redisReport{}
+		report.add("hosts", getRedisKey(fmt.Sprintf("hosts:%v", test.timeout)))
+		if _, err := args.run(report); err != nil {
+			t.Errorf("timeout %v: %v", test.timeout, err)
+			continue
+		}
+		if ttl, _ := redis.Int(conn.Do("TTL", report.keys[0])); ttl <= test.want-5 || ttl > test.want {
+			t.Errorf("timeout %v: ttl = %v, want %v", test.timeout, ttl, test.want)
+		}
+	}
+}
+
+func TestReportScriptInvalid(t *testing.T) {
+	setRedisServer(t)
+	report := redisReport{}
+	report.add("info", getRedisHostKeyOf("info", "host-a"))
+
+	tests := []struct {
+		name   string
+		modify func(*reportArgs)
+		want   string
+	}{
+		{"retention", func(a *reportArgs) { a.retain = 0 }, "invalid time arguments"},
+		{"uuid", func(a *reportArgs) { a.uuid = "" }, "empty uuid"},
+		{"sample", func(a *reportArgs) { a.data = "not json" }, "sample is not valid JSON"},
+		{"info", func(a *reportArgs) { a.info = `"text"` }, "info is not a JSON object"},
+		{"messages", func(a *reportArgs) { a.messages = "x" }, "messages is not a JSON array"},
+	}
+	for _, test := range tests {
+		args := defaultReportArgs()
+		test.modify(&args)
+		if _, err := args.run(report); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: err = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestJanitorScript(t *testing.T) {
+	conn := setRedisServer(t)
+	oldTimeout, oldInterval, oldFormer, oldLegacy := DATA_TIMEOUT, JANITOR_INTERVAL, JANITOR_FORMER_TIME, REDIS_LEGACY_HASHES
+	DATA_TIMEOUT, JANITOR_INTERVAL, JANITOR_FORMER_TIME, REDIS_LEGACY_HASHES = 100, 0, 0, true
+	t.Cleanup(func() {
+		DATA_TIMEOUT, JANITOR_INTERVAL, JANITOR_FORMER_TIME, REDIS_LEGACY_HASHES = oldTimeout, oldInterval, oldFormer, oldLegacy
+	})
+
+	now := time.Now().Unix()
+	for uuid, seen := range map[string]int64{"stale": now - 500, "fresh": now} {
+		args := defaultReportArgs()
+		args.now, args.uuid = seen, uuid
+		args.host = fmt.Sprintf(`{"last_seen":"%v"}`, seen)
+		report := redisReport{}
+		for _, kind := range []string{"info", "host", "collection"} {
+			report.add(kind, getRedisHostKeyOf(kind, uuid))
+		}
+		report.add("hosts", getRedisKey("hosts"))
+		report.add("hashes", getRedisKey("hashes"))
+		if _, err := args.run(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runJanitor()
+
+	hosts, _ := redis.Strings(conn.Do("ZRANGE", getRedisKey("hosts"), 0, -1))
+	if len(hosts) != 1 || hosts[0] != "fresh" {
+		t.Errorf("hosts = %v, want fresh only", hosts)
+	}
+	if legacy, _ := redis.Strings(conn.Do("HKEYS", getRedisKey("hashes"))); len(legacy) != 1 || legacy[0] != "fresh" {
+		t.Errorf("legacy hashes = %v, want fresh only", legacy)
+	}
+	for _, kind := range []string{"info", "host", "collection"} {
+		if exists, _ := redis.Int(conn.Do("EXISTS", getRedisHostKeyOf(kind, "stale"))); exists != 0 {
+			t.Errorf("%v of stale host still exists", kind)
+		}
+		if exists, _ := redis.Int(conn.Do("EXISTS", getRedisHostKeyOf(kind, "fresh"))); exists != 1 {
+			t.Errorf("%v of fresh host was removed", kind)
+		}
+	}
+}
+
+func TestReportLegacyHashes(t *testing.T) {
+	conn := setRedisServer(t)
+	oldUUID, oldRetention, oldAlive, oldStorage, oldLegacy, oldChecked :=
+		UUID, RETENTION_TIME, ALIVE_CHECK_TIME, REDIS_STORAGE, REDIS_LEGACY_HASHES, REDIS_SCHEMA_CHECKED
+	t.Cleanup(func() {
+		UUID, RETENTION_TIME, ALIVE_CHECK_TIME, REDIS_STORAGE, REDIS_LEGACY_HASHES, REDIS_SCHEMA_CHECKED =
+			oldUUID, oldRetention, oldAlive, oldStorage, oldLegacy, oldChecked
+	})
+	UUID, RETENTION_TIME, ALIVE_CHECK_TIME, REDIS_STORAGE = "host-a", 600, 30, "zset"
+	info := map[string]interface{}{"Hostname": "a"}
+	sample := `{"Load":{"idle":"90"}}`
+
+	// Another agent still on the legacy layout keeps the hashes alive
+	conn.Do("HSET", getRedisKey("hashes"), "host-b", "198.51.100.x")
+	conn.Do("HSET", getRedisKey("schema"), "host-b", "1")
+
+	REDIS_LEGACY_HASHES, REDIS_SCHEMA_CHECKED = true, false
+	if err := reportRedis(info, sample); err != nil {
+		t.Fatal(err)
+	}
+	if fields, _ := redis.Strings(conn.Do("HKEYS", getRedisKey("schema"))); len(fields) != 2 {
+		t.Errorf("legacy schema = %v, want host-a and host-b", fields)
+	}
+
+	// A restart with the flag off drops this host even though its schema is current
+	REDIS_LEGACY_HASHES, REDIS_SCHEMA_CHECKED = false, false
+	if err := reportRedis(info, sample); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{getRedisKey("hashes"), getRedisKey("schema")} {
+		if fields, _ := redis.Strings(conn.Do("HKEYS", key)); len(fields) != 1 || fields[0] != "host-b" {
+			t.Errorf("%v = %v, want host-b only", key, fields)
+		}
+	}
+	if schema, _ := redis.String(conn.Do("HGET", getRedisHostKey("host"), "schema")); schema != "2" {
+		t.Errorf("host schema = %v, want 2", schema)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestCRC16(t *testing.T) {
+	// Check value of CRC16/XMODEM, also listed in the Redis Cluster spec
+	if got := crc16("123456789"); got != 0x31C3 {
+		t.Errorf("crc16(123456789) = %#x, want 0x31c3", got)
+	}
+	if got := crc16(""); got != 0 {
+		t.Errorf("crc16() = %#x, want 0", got)
+	}
+}
+
+func TestGetKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"123456789", 0x31C3},
+		{"foo", 12182},
+		{"bar", 5061},
+		// Only the first hash tag counts
+		{"{foo}:hosts", 12182},
+		{"monitor:info:{foo}", 12182},
+		{"x{bar}{foo}", 5061},
+		// An empty tag hashes the whole key
+		{"foo{}{bar}", int(crc16("foo{}{bar}") % REDIS_CLUSTER_SLOTS)},
+		{"foo{bar", int(crc16("foo{bar") % REDIS_CLUSTER_SLOTS)},
+	}
+	for _, test := range tests {
+		if got := getKeySlot(test.key); got != test.want {
+			t.Errorf("getKeySlot(%v) = %v, want %v", test.key, got, test.want)
+		}
+	}
+
+	// Keys of one host share a slot, so one script call reaches them all
+	oldMode := REDIS_MODE
+	REDIS_MODE = "cluster"
+	t.Cleanup(func() { REDIS_MODE = oldMode })
+	if getKeySlot(getRedisHostKeyOf("info", "a")) != getKeySlot(getRedisHostKeyOf("alive", "a")) {
+		t.Error("host keys hash to different slots")
+	}
+}
+
+func TestRunPendingReports(t *testing.T) {
+	reports := []redisReport{{roles: []string{"info"}}, {roles: []string{"hosts"}}}
+	done := make([]bool, len(reports))
+	calls := []int{}
+	fail := true
+	run := func(i int, r redisReport) error {
+		calls = append(calls, i)
+		// The shared report fails once, e.g. on a moved slot
+		if i == 1 && fail {
+			fail = false
+			return errors.New("MOVED 1234 10.0.0.2:6379")
+		}
+		return nil
+	}
+
+	if err := runPendingReports(reports, done, run); err == nil {
+		t.Fatal("runPendingReports() = nil, want the MOVED error")
+	}
+	if err := runPendingReports(reports, done, run); err != nil {
+		t.Fatal(err)
+	}
+	// The host report and its publishes ran once only
+	if want := []int{0, 1, 1}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if err := runPendingReports(reports, done, run); err != nil || len(calls) != 3 {
+		t.Errorf("runPendingReports() after success ran %v", calls[3:])
+	}
+}
+
+// Writes v as a RESP reply: strings as bulk strings, nil as a null bulk string
+func writeRESP(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case error:
+		fmt.Fprintf(w, "-%v\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%v\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%v\r\n%v\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%v\r\n", len(v))
+		for _, item := range v {
+			writeRESP(w, item)
+		}
+	}
+}
+
+// Serves RESP commands with handle, enough to stand in for a sentinel or a
+// cluster node
+func startFakeRedis(t *testing.T, handle func(args []string) interface{}) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || !strings.HasPrefix(line, "*") {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					args := make([]string, n)
+					for i := range args {
+						reader.ReadString('\n')
+						arg, _ := reader.ReadString('\n')
+						args[i] = strings.TrimSuffix(arg, "\r\n")
+					}
+					writeRESP(writer, handle(args))
+					writer.Flush()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// Recorded from a three master cluster, the second master reports no host
+var CLUSTER_SLOTS_REPLY = []interface{}{
+	[]interface{}{int64(0), int64(5460),
+		[]interface{}{[]byte("10.0.0.1"), int64(7000), []byte("a1")},
+		[]interface{}{[]byte("10.0.0.4"), int64(7003), []byte("b1")},
+	},
+	[]interface{}{int64(5461), int64(10922),
+		[]interface{}{[]byte(""), int64(7001), []byte("a2")},
+	},
+	[]interface{}{int64(10923), int64(16383),
+		[]interface{}{[]byte("10.0.0.3"), int64(7002), []byte("a3")},
+	},
+	// Malformed entries are skipped
+	[]interface{}{int64(1), int64(2)},
+	[]byte("garbage"),
+}
+
+func setClusterMode(t *testing.T, nodes ...string) {
+	t.Helper()
+	oldMode, oldNodes, oldTable, oldPools := REDIS_MODE, REDIS_CLUSTER_NODES, REDIS_CLUSTER_TABLE, REDIS_CLUSTER_POOLS
+	REDIS_MODE, REDIS_CLUSTER_NODES, REDIS_CLUSTER_TABLE, REDIS_CLUSTER_POOLS = "cluster", nodes, nil, map[string]*redis.Pool{}
+	t.Cleanup(func() {
+		for _, pool := range REDIS_CLUSTER_POOLS {
+			pool.Close()
+		}
+		REDIS_MODE, REDIS_CLUSTER_NODES, REDIS_CLUSTER_TABLE, REDIS_CLUSTER_POOLS = oldMode, oldNodes, oldTable, oldPools
+	})
+}
+
+func TestBuildClusterTable(t *testing.T) {
+	table := buildClusterTable("10.0.0.9:7005", CLUSTER_SLOTS_REPLY)
+
+	tests := []struct {
+		slot int
+		want string
+	}{
+		{0, "10.0.0.1:7000"},
+		{5460, "10.0.0.1:7000"},
+		// An empty host is the node that answered
+		{5461, "10.0.0.9:7001"},
+		{10922, "10.0.0.9:7001"},
+		{10923, "10.0.0.3:7002"},
+		{16383, "10.0.0.3:7002"},
+	}
+	for _, test := range tests {
+		if table[test.slot] != test.want {
+			t.Errorf("slot %v = %v, want %v", test.slot, table[test.slot], test.want)
+		}
+	}
+
+	// Bracketed IPv6 nodes keep their brackets
+	table = buildClusterTable("[2001:db8::9]:7005", CLUSTER_SLOTS_REPLY)
+	if table[5461] != "[2001:db8::9]:7001" {
+		t.Errorf("slot 5461 = %v, want [2001:db8::9]:7001", table[5461])
+	}
+
+	// Slots missing from the reply stay unserved
+	table = buildClusterTable("10.0.0.9:7005", CLUSTER_SLOTS_REPLY[:1])
+	if table[5461] != "" {
+		t.Errorf("slot 5461 = %v, want unserved", table[5461])
+	}
+}
+
+func TestGetClusterPool(t *testing.T) {
+	setClusterMode(t)
+	REDIS_CLUSTER_TABLE = buildClusterTable("10.0.0.9:7005", CLUSTER_SLOTS_REPLY[:1])
+
+	// {a} hashes to slot 15495, served by no node
+	if _, err := getClusterPool("monitor:info:{a}"); err == nil {
+		t.Error("getClusterPool(unserved) = nil error, want an error")
+	}
+
+	// foo hashes to slot 12182, bar to 5061
+	REDIS_CLUSTER_TABLE = buildClusterTable("10.0.0.9:7005", CLUSTER_SLOTS_REPLY)
+	foo, err := getClusterPool("{foo}:hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, _ := getClusterPool("bar")
+	again, _ := getClusterPool("monitor:info:{foo}")
+	if foo != again || foo == bar {
+		t.Error("keys of one node should share a pool, other nodes get their own")
+	}
+	if pool, ok := REDIS_CLUSTER_POOLS["10.0.0.3:7002"]; !ok || pool != foo {
+		t.Errorf("pools = %v, want {foo} on 10.0.0.3:7002", REDIS_CLUSTER_POOLS)
+	}
+}
+
+func TestRefreshClusterSlots(t *testing.T) {
+	node := startFakeRedis(t, func(args []string) interface{} {
+		if strings.ToUpper(strings.Join(args, " ")) != "CLUSTER SLOTS" {
+			return errors.New("ERR unknown command")
+		}
+		return []interface{}{
+			[]interface{}{0, 16383, []interface{}{"", 7001, "a1"}},
+		}
+	})
+
+	// The first node is down, the next one answers
+	setClusterMode(t, "127.0.0.1:1", node)
+	if err := refreshClusterSlots(); err != nil {
+		t.Fatal(err)
+	}
+	if REDIS_CLUSTER_TABLE[0] != "127.0.0.1:7001" || REDIS_CLUSTER_TABLE[16383] != "127.0.0.1:7001" {
+		t.Errorf("table = %v..., want 127.0.0.1:7001", REDIS_CLUSTER_TABLE[0])
+	}
+
+	REDIS_CLUSTER_NODES = []string{"127.0.0.1:1"}
+	if err := refreshClusterSlots(); err == nil {
+		t.Error("refreshClusterSlots() without nodes = nil, want an error")
+	}
+}
+
+func TestGetSentinelMaster(t *testing.T) {
+	sentinel := startFakeRedis(t, func(args []string) interface{} {
+		if len(args) == 3 && strings.ToLower(args[1]) == "get-master-addr-by-name" && args[2] == "mymaster" {
+			return []interface{}{"10.0.0.1", "6379"}
+		}
+		return nil
+	})
+
+	oldSentinels, oldMaster := REDIS_SENTINELS, REDIS_MASTER
+	t.Cleanup(func() { REDIS_SENTINELS, REDIS_MASTER = oldSentinels, oldMaster })
+
+	// The first sentinel is down, the next one answers
+	REDIS_SENTINELS, REDIS_MASTER = []string{"127.0.0.1:1", sentinel}, "mymaster"
+	if addr, err := getSentinelMaster(); err != nil || addr != "10.0.0.1:6379" {
+		t.Errorf("getSentinelMaster() = %v, %v, want 10.0.0.1:6379", addr, err)
+	}
+
+	REDIS_MASTER = "other"
+	if addr, err := getSentinelMaster(); err == nil {
+		t.Errorf("getSentinelMaster(unknown) = %v, want an error", addr)
+	}
+}
+
+func TestSentinelPoolRole(t *testing.T) {
+	role := "master"
+	server := startFakeRedis(t, func(args []string) interface{} {
+		if strings.ToUpper(args[0]) == "ROLE" {
+			return []interface{}{role, 0, []interface{}{}}
+		}
+		return "PONG"
+	})
+
+	oldMode := REDIS_MODE
+	REDIS_MODE = "sentinel"
+	t.Cleanup(func() { REDIS_MODE = oldMode })
+
+	pool := newRedisPool(func() (redis.Conn, error) { return redis.Dial("tcp", server) })
+	defer pool.Close()
+	conn, err := pool.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	idle := time.Now().Add(-time.Minute)
+	if err := pool.TestOnBorrow(conn, idle); err != nil {
+		t.Errorf("TestOnBorrow(master) = %v, want nil", err)
+	}
+	// After a failover the old master comes back as a replica
+	role = "slave"
+	if err := pool.TestOnBorrow(conn, idle); err == nil {
+		t.Error("TestOnBorrow(replica) = nil, want an error")
+	}
+}
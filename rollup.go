@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type rollupInterval struct {
+	interval  int64
+	retention int64
+}
+
+type rollupStat struct {
+	min   float64
+	max   float64
+	sum   float64
+	count int
+}
+
+type rollupBucket struct {
+	start   int64
+	samples int
+	metrics map[string]*rollupStat
+}
+
+// A finished bucket waiting to be written
+type rollupPoint struct {
+	Time int64  `json:"time"`
+	Data string `json:"data"`
+}
+
+// Subset of the aggregate stat the rollups are computed from
+type rollupSample struct {
+	Load   map[string]string `json:"Load"`
+	Memory struct {
+		Mem struct {
+			Percent string `json:"percent"`
+		} `json:"Mem"`
+	} `json:"Memory"`
+	Disk map[string]struct {
+		Percent string `json:"percent"`
+	} `json:"Disk"`
+	Network struct {
+		RX struct {
+			Bytes uint64 `json:"bytes"`
+		} `json:"RX"`
+		TX struct {
+			Bytes uint64 `json:"bytes"`
+		} `json:"TX"`
+	} `json:"Network"`
+}
+
+var (
+	ROLLUP_BUCKETS     = map[int64]*rollupBucket{}
+	ROLLUP_PENDING     = map[int64][]rollupPoint{}
+	ROLLUP_FORMER_TIME int64
+)
+
+// ROLLUP_INTERVALS format: "interval:retention,..." in seconds
+func parseRollupIntervals(config []string) []rollupInterval {
+	intervals := []rollupInterval{}
+	for _, entry := range config {
+		interval, retention, _ := strings.Cut(entry, ":")
+		i, err := strconv.ParseInt(strings.TrimSpace(interval), 10, 64)
+		if err != nil || i <= 0 {
+			logMessage(ERROR, fmt.Sprintf("Invalid rollup interval: %v", entry))
+			continue
+		}
+		r, err := strconv.ParseInt(strings.TrimSpace(retention), 10, 64)
+		if err != nil || r < i {
+			logMessage(ERROR, fmt.Sprintf("Invalid rollup retention: %v", entry))
+			continue
+		}
+		intervals = append(intervals, rollupInterval{interval: i, retention: r})
+	}
+	return intervals
+}
+
+// Metric keys are "<group>" or "<group>:<name>"
+func getRollupMetrics(jsonAggregateStat string, now int64) map[string]float64 {
+	var sample rollupSample
+	if err := json.Unmarshal([]byte(jsonAggregateStat), &sample); err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to parse sample for rollup: %v", err))
+		return nil
+	}
+
+	metrics := map[string]float64{}
+	if idle, err := strconv.ParseFloat(sample.Load["idle"], 64); err == nil {
+		metrics["cpu"] = 100 - idle
+	}
+	if percent, err := strconv.ParseFloat(sample.Memory.Mem.Percent, 64); err == nil {
+		metrics["memory"] = percent
+	}
+	for mount, usage := range sample.Disk {
+		if percent, err := strconv.ParseFloat(usage.Percent, 64); err == nil {
+			metrics["disk:"+mount] = percent
+		}
+	}
+
+	// Network holds bytes since the previous sample, turn it into bytes per second
+	if ROLLUP_FORMER_TIME != 0 && now > ROLLUP_FORMER_TIME {
+		elapsed := float64(now - ROLLUP_FORMER_TIME)
+		metrics["network:rx"] = float64(sample.Network.RX.Bytes) / elapsed
+		metrics["network:tx"] = float64(sample.Network.TX.Bytes) / elapsed
+	}
+	ROLLUP_FORMER_TIME = now
+
+	return metrics
+}
+
+func formatRollupBucket(interval int64, bucket *rollupBucket) string {
+	data := map[string]interface{}{
+		"time":     bucket.start,
+		"interval": interval,
+		"samples":  bucket.samples,
+	}
+
+	names := make([]string, 0, len(bucket.metrics))
+	for name := range bucket.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		stat := bucket.metrics[name]
+		value := map[string]string{
+			"min": fmt.Sprintf("%.2f", stat.min),
+			"avg": fmt.Sprintf("%.2f", stat.sum/float64(stat.count)),
+			"max": fmt.Sprintf("%.2f", stat.max),
+		}
+		group, key, nested := strings.Cut(name, ":")
+		if !nested {
+			data[group] = value
+			continue
+		}
+		if _, ok := data[group]; !ok {
+			data[group] = map[string]interface{}{}
+		}
+		data[group].(map[string]interface{})[key] = value
+	}
+
+	jsonData, _ := json.Marshal(data)
+	return string(jsonData)
+}
+
+// Adds the sample to the bucket of every interval. Buckets closed by this
+// sample move to ROLLUP_PENDING until they are written.
+func addRollupSample(jsonAggregateStat string, now int64) {
+	metrics := getRollupMetrics(jsonAggregateStat, now)
+	if len(metrics) == 0 {
+		return
+	}
+
+	for _, rollup := range ROLLUP_INTERVALS {
+		start := now - now%rollup.interval
+		bucket := ROLLUP_BUCKETS[rollup.interval]
+		if bucket != nil && bucket.start != start {
+			// Points that could not be written before their retention ran out are dropped
+			pending := []rollupPoint{}
+			for _, point := range ROLLUP_PENDING[rollup.interval] {
+				if point.Time > now-rollup.retention {
+					pending = append(pending, point)
+				}
+			}
+			ROLLUP_PENDING[rollup.interval] = append(pending, rollupPoint{
+				Time: bucket.start,
+				Data: formatRollupBucket(rollup.interval, bucket),
+			})
+			bucket = nil
+		}
+		if bucket == nil {
+			bucket = &rollupBucket{start: start, metrics: map[string]*rollupStat{}}
+			ROLLUP_BUCKETS[rollup.interval] = bucket
+		}
+
+		bucket.samples++
+		for name, value := range metrics {
+			stat, ok := bucket.metrics[name]
+			if !ok {
+				bucket.metrics[name] = &rollupStat{min: value, max: value, sum: value, count: 1}
+				continue
+			}
+			stat.min = min(stat.min, value)
+			stat.max = max(stat.max, value)
+			stat.sum += value
+			stat.count++
+		}
+	}
+}
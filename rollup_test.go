@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func setRollups(t *testing.T, intervals ...rollupInterval) {
+	t.Helper()
+	oldIntervals, oldBuckets, oldPending, oldTime := ROLLUP_INTERVALS, ROLLUP_BUCKETS, ROLLUP_PENDING, ROLLUP_FORMER_TIME
+	ROLLUP_INTERVALS, ROLLUP_BUCKETS, ROLLUP_PENDING, ROLLUP_FORMER_TIME = intervals, map[int64]*rollupBucket{}, map[int64][]rollupPoint{}, 0
+	t.Cleanup(func() {
+		ROLLUP_INTERVALS, ROLLUP_BUCKETS, ROLLUP_PENDING, ROLLUP_FORMER_TIME = oldIntervals, oldBuckets, oldPending, oldTime
+	})
+}
+
+func rollupStatJSON(idle, mem string, rx, tx uint64) string {
+	return fmt.Sprintf(`{"Load":{"idle":%q},"Memory":{"Mem":{"percent":%q}},`+
+		`"Disk":{"/":{"percent":"40.00"},"/data":{"percent":"bad"}},`+
+		`"Network":{"RX":{"bytes":%v},"TX":{"bytes":%v}}}`, idle, mem, rx, tx)
+}
+
+func TestGetRollupMetrics(t *testing.T) {
+	setRollups(t)
+
+	tests := []struct {
+		name string
+		stat string
+		now  int64
+		want map[string]float64
+	}{
+		{"invalid", "not json", 1000, nil},
+		// No network rate without a previous sample
+		{"first", rollupStatJSON("75.00", "50.00", 1000, 500), 1000, map[string]float64{
+			"cpu": 25, "memory": 50, "disk:/": 40,
+		}},
+		{"rates", rollupStatJSON("90.00", "60.00", 1000, 500), 1010, map[string]float64{
+			"cpu": 10, "memory": 60, "disk:/": 40, "network:rx": 100, "network:tx": 50,
+		}},
+		// A clock going back skips the rate
+		{"clock back", rollupStatJSON("90.00", "60.00", 1000, 500), 1005, map[string]float64{
+			"cpu": 10, "memory": 60, "disk:/": 40,
+		}},
+		{"missing", `{"Load":{},"Memory":{"Mem":{}}}`, 1025, map[string]float64{
+			"network:rx": 0, "network:tx": 0,
+		}},
+	}
+	for _, test := range tests {
+		if got := getRollupMetrics(test.stat, test.now); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: getRollupMetrics() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAddRollupSample(t *testing.T) {
+	setRollups(t, rollupInterval{interval: 60, retention: 180}, rollupInterval{interval: 300, retention: 600})
+
+	samples := []struct {
+		idle string
+		now  int64
+	}{
+		// 659 and 660 sit on either side of a minute boundary
+		{"90.00", 600},
+		{"70.00", 630},
+		{"80.00", 659},
+		{"50.00", 660},
+	}
+	for _, sample := range samples {
+		addRollupSample(rollupStatJSON(sample.idle, "50.00", 600, 0), sample.now)
+	}
+
+	// The closed minute is pending, the five minute bucket is still open
+	pending := ROLLUP_PENDING[60]
+	if len(pending) != 1 || pending[0].Time != 600 || len(ROLLUP_PENDING[300]) != 0 {
+		t.Fatalf("pending = %v, want the bucket at 600 for 60 only", ROLLUP_PENDING)
+	}
+	var point map[string]interface{}
+	if err := json.Unmarshal([]byte(pending[0].Data), &point); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"time": 600.0, "interval": 60.0, "samples": 3.0,
+		"cpu":    map[string]interface{}{"min": "10.00", "avg": "20.00", "max": "30.00"},
+		"memory": map[string]interface{}{"min": "50.00", "avg": "50.00", "max": "50.00"},
+		"disk":   map[string]interface{}{"/": map[string]interface{}{"min": "40.00", "avg": "40.00", "max": "40.00"}},
+		// No rate for the first sample, then 600 bytes over 30s and 29s
+		"network": map[string]interface{}{
+			"rx": map[string]interface{}{"min": "20.00", "avg": "20.34", "max": "20.69"},
+			"tx": map[string]interface{}{"min": "0.00", "avg": "0.00", "max": "0.00"},
+		},
+	}
+	if !reflect.DeepEqual(point, want) {
+		t.Errorf("point = %v, want %v", point, want)
+	}
+	if bucket := ROLLUP_BUCKETS[300]; bucket.start != 600 || bucket.samples != 4 {
+		t.Errorf("bucket 300 = %v, %v samples, want 600, 4", bucket.start, bucket.samples)
+	}
+	if bucket := ROLLUP_BUCKETS[60]; bucket.start != 660 || bucket.samples != 1 || bucket.metrics["cpu"].max != 50 {
+		t.Errorf("bucket 60 = %v, %v samples, want 660, 1", bucket.start, bucket.samples)
+	}
+
+	// Unwritten points older than the retention are pruned as new buckets close
+	tests := []struct {
+		now  int64
+		want []int64
+	}{
+		{720, []int64{600, 660}},
+		{780, []int64{660, 720}},
+		{840, []int64{720, 780}},
+		// Skipped minutes leave no points
+		{1200, []int64{840}},
+	}
+	for _, test := range tests {
+		addRollupSample(rollupStatJSON("50.00", "50.00", 0, 0), test.now)
+		times := []int64{}
+		for _, point := range ROLLUP_PENDING[60] {
+			times = append(times, point.Time)
+		}
+		if !reflect.DeepEqual(times, test.want) {
+			t.Errorf("pending at %v = %v, want %v", test.now, times, test.want)
+		}
+	}
+	if pending := ROLLUP_PENDING[300]; len(pending) != 1 || pending[0].Time != 600 {
+		t.Errorf("pending 300 = %v, want the bucket at 600", pending)
+	}
+
+	// Samples without metrics are not counted
+	addRollupSample("not json", 1210)
+	if ROLLUP_BUCKETS[60].samples != 1 {
+		t.Errorf("bucket 60 samples = %v, want 1", ROLLUP_BUCKETS[60].samples)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	SYSTEMD_DEST      = "org.freedesktop.systemd1"
+	SYSTEMD_PATH      = "/org/freedesktop/systemd1"
+	SYSTEMD_MANAGER   = "org.freedesktop.systemd1.Manager"
+	SYSTEMD_UNIT      = "org.freedesktop.systemd1.Unit"
+	SYSTEMD_INTERFACE = "org.freedesktop.systemd1."
+)
+
+// Matches the a(ssssssouso) signature returned by ListUnits*
+type systemdUnit struct {
+	Name        string
+	Description string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	Following   string
+	Path        dbus.ObjectPath
+	JobId       uint32
+	JobType     string
+	JobPath     dbus.ObjectPath
+}
+
+// The manager calls the collector needs, so tests can swap the bus out
+type systemdManager interface {
+	ListUnitsFiltered(states []string) ([]systemdUnit, error)
+	LoadUnit(name string) (systemdUnitObject, error)
+}
+
+type systemdUnitObject interface {
+	GetProperty(property string) (dbus.Variant, error)
+}
+
+type dbusManager struct {
+	conn   *dbus.Conn
+	object dbus.BusObject
+}
+
+func (m dbusManager) ListUnitsFiltered(states []string) ([]systemdUnit, error) {
+	var units []systemdUnit
+	err := m.object.Call(SYSTEMD_MANAGER+".ListUnitsFiltered", 0, states).Store(&units)
+	return units, err
+}
+
+func (m dbusManager) LoadUnit(name string) (systemdUnitObject, error) {
+	var path dbus.ObjectPath
+	if err := m.object.Call(SYSTEMD_MANAGER+".LoadUnit", 0, name).Store(&path); err != nil {
+		return nil, err
+	}
+	return m.conn.Object(SYSTEMD_DEST, path), nil
+}
+
+var SYSTEMD_FORMER map[string]uint64 = nil
+var SYSTEMD_FORMER_TIME time.Time
+
+// SYSTEMD_UNITS without a type suffix, e.g. "nginx" or "nginx.", are services
+func parseSystemdUnits(config []string) []string {
+	units := []string{}
+	for _, entry := range config {
+		unit := strings.TrimRight(entry, ".")
+		if unit == "" {
+			logMessage(ERROR, fmt.Sprintf("Invalid systemd unit: %v", entry))
+			continue
+		}
+		if !strings.Contains(unit, ".") {
+			unit += ".service"
+		}
+		units = append(units, unit)
+	}
+	return units
+}
+
+func getSystemdConn() (*dbus.Conn, error) {
+	// The private socket needs no bus daemon, but is only available to root
+	if SYSTEMD_BUS != "system" {
+		conn, err := dbus.Dial(SYSTEMD_BUS)
+		if err == nil {
+			err = conn.Auth([]dbus.Auth{dbus.AuthExternal(strconv.Itoa(os.Getuid()))})
+			if err == nil {
+				return conn, nil
+			}
+			conn.Close()
+		}
+		logMessage(DEBUG, fmt.Sprintf("Fail to connect to %v, falling back to system bus: %v", SYSTEMD_BUS, err))
+	}
+
+	conn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = conn.Hello(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func getFailedUnits(manager systemdManager) ([]map[string]string, error) {
+	units, err := manager.ListUnitsFiltered([]string{"failed"})
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make([]map[string]string, 0, len(units))
+	for _, unit := range units {
+		failed = append(failed, map[string]string{
+			"name":        unit.Name,
+			"description": unit.Description,
+			"active":      unit.ActiveState,
+			"sub":         unit.SubState,
+		})
+	}
+	return failed, nil
+}
+
+func getUnitProperty(unit systemdUnitObject, property string) interface{} {
+	value, err := unit.GetProperty(property)
+	if err != nil {
+		return nil
+	}
+	return value.Value()
+}
+
+func getUnitState(manager systemdManager, name string, elapsed float64, current map[string]uint64) map[string]interface{} {
+	unit, err := manager.LoadUnit(name)
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to load unit %v: %v", name, err))
+		return map[string]interface{}{"active": "unknown", "sub": "unknown"}
+	}
+
+	state := map[string]interface{}{
+		"active": FirstNonEmpty("unknown", getUnitProperty(unit, SYSTEMD_UNIT+".ActiveState")),
+		"sub":    FirstNonEmpty("unknown", getUnitProperty(unit, SYSTEMD_UNIT+".SubState")),
+	}
+
+	// Accounting properties live on the type specific interface, e.g. .Service or .Slice
+	suffix := name[strings.LastIndex(name, ".")+1:]
+	if suffix == "" {
+		return state
+	}
+	iface := SYSTEMD_INTERFACE + strings.ToUpper(suffix[:1]) + suffix[1:]
+
+	if restarts, ok := getUnitProperty(unit, iface+".NRestarts").(uint32); ok {
+		state["restarts"] = restarts
+	}
+	if memory, ok := getUnitProperty(unit, iface+".MemoryCurrent").(uint64); ok && memory != math.MaxUint64 {
+		state["memory"] = fmt.Sprintf("%.2f", float64(memory)/1024/1024)
+	}
+	if usage, ok := getUnitProperty(unit, iface+".CPUUsageNSec").(uint64); ok && usage != math.MaxUint64 {
+		current[name] = usage
+		cpuPercent := 0.0
+		if former, ok := SYSTEMD_FORMER[name]; ok && elapsed > 0 && usage > former {
+			cpuPercent = float64(usage-former) / 1e9 / elapsed * 100
+		}
+		state["cpu"] = fmt.Sprintf("%.2f", cpuPercent)
+	}
+
+	return state
+}
+
+func getSystemd() string {
+	// Get failed units and state of configured units from systemd
+	if SYSTEMD_BUS == "" {
+		return "{}"
+	}
+
+	conn, err := getSystemdConn()
+	if err != nil {
+		logMessage(DEBUG, fmt.Sprintf("Fail to connect to systemd: %v", err))
+		return "{}"
+	}
+	defer conn.Close()
+
+	return getSystemdState(dbusManager{conn: conn, object: conn.Object(SYSTEMD_DEST, SYSTEMD_PATH)})
+}
+
+func getSystemdState(manager systemdManager) string {
+	failed, err := getFailedUnits(manager)
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to list failed units: %v", err))
+		return "{}"
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(SYSTEMD_FORMER_TIME).Seconds()
+	current := make(map[string]uint64, len(SYSTEMD_UNITS))
+	units := make(map[string]interface{}, len(SYSTEMD_UNITS))
+
+	for _, name := range SYSTEMD_UNITS {
+		units[name] = getUnitState(manager, name, elapsed, current)
+	}
+
+	SYSTEMD_FORMER = current
+	SYSTEMD_FORMER_TIME = now
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"failed": failed,
+		"units":  units,
+	})
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type fakeSystemdUnit map[string]interface{}
+
+func (u fakeSystemdUnit) GetProperty(property string) (dbus.Variant, error) {
+	value, ok := u[property]
+	if !ok {
+		return dbus.Variant{}, errors.New("no such property")
+	}
+	return dbus.MakeVariant(value), nil
+}
+
+type fakeSystemdManager struct {
+	failed []systemdUnit
+	units  map[string]fakeSystemdUnit
+}
+
+func (m *fakeSystemdManager) ListUnitsFiltered(states []string) ([]systemdUnit, error) {
+	if !reflect.DeepEqual(states, []string{"failed"}) {
+		return nil, errors.New("unexpected filter")
+	}
+	return m.failed, nil
+}
+
+func (m *fakeSystemdManager) LoadUnit(name string) (systemdUnitObject, error) {
+	unit, ok := m.units[name]
+	if !ok {
+		return nil, errors.New("no such unit")
+	}
+	return unit, nil
+}
+
+func setSystemdUnits(t *testing.T, units ...string) {
+	t.Helper()
+	oldUnits, oldFormer, oldTime := SYSTEMD_UNITS, SYSTEMD_FORMER, SYSTEMD_FORMER_TIME
+	SYSTEMD_UNITS, SYSTEMD_FORMER, SYSTEMD_FORMER_TIME = units, nil, time.Time{}
+	t.Cleanup(func() {
+		SYSTEMD_UNITS, SYSTEMD_FORMER, SYSTEMD_FORMER_TIME = oldUnits, oldFormer, oldTime
+	})
+}
+
+func TestGetFailedUnits(t *testing.T) {
+	manager := &fakeSystemdManager{failed: []systemdUnit{
+		{Name: "a.service", Description: "A", LoadState: "loaded", ActiveState: "failed", SubState: "failed"},
+		{Name: "b.mount", Description: "B", LoadState: "loaded", ActiveState: "failed", SubState: "failed"},
+	}}
+
+	got, err := getFailedUnits(manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{
+		{"name": "a.service", "description": "A", "active": "failed", "sub": "failed"},
+		{"name": "b.mount", "description": "B", "active": "failed", "sub": "failed"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getFailedUnits() = %v, want %v", got, want)
+	}
+}
+
+func TestParseSystemdUnits(t *testing.T) {
+	got := parseSystemdUnits([]string{"nginx", "nginx.", "worker.slice", "tmp.mount..", ".", "..", "a.b.service"})
+	want := []string{"nginx.service", "nginx.service", "worker.slice", "tmp.mount", "a.b.service"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSystemdUnits() = %v, want %v", got, want)
+	}
+}
+
+func TestGetUnitState(t *testing.T) {
+	setSystemdUnits(t)
+	manager := &fakeSystemdManager{units: map[string]fakeSystemdUnit{
+		"web.service": {
+			SYSTEMD_UNIT + ".ActiveState":               "active",
+			SYSTEMD_UNIT + ".SubState":                  "running",
+			SYSTEMD_INTERFACE + "Service.NRestarts":     uint32(2),
+			SYSTEMD_INTERFACE + "Service.MemoryCurrent": uint64(3 * 1024 * 1024),
+			SYSTEMD_INTERFACE + "Service.CPUUsageNSec":  uint64(4e9),
+		},
+		// Accounting disabled, systemd reports MaxUint64 for both
+		"idle.slice": {
+			SYSTEMD_UNIT + ".ActiveState":             "active",
+			SYSTEMD_UNIT + ".SubState":                "active",
+			SYSTEMD_INTERFACE + "Slice.MemoryCurrent": uint64(math.MaxUint64),
+			SYSTEMD_INTERFACE + "Slice.CPUUsageNSec":  uint64(math.MaxUint64),
+		},
+	}}
+
+	current := map[string]uint64{}
+	got := getUnitState(manager, "web.service", 10, current)
+	want := map[string]interface{}{"active": "active", "sub": "running", "restarts": uint32(2), "memory": "3.00", "cpu": "0.00"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getUnitState(web) = %v, want %v", got, want)
+	}
+
+	// The second sample reports usage since the first
+	SYSTEMD_FORMER = current
+	manager.units["web.service"][SYSTEMD_INTERFACE+"Service.CPUUsageNSec"] = uint64(6e9)
+	got = getUnitState(manager, "web.service", 10, map[string]uint64{})
+	if got["cpu"] != "20.00" {
+		t.Errorf("getUnitState(web) cpu = %v, want 20.00", got["cpu"])
+	}
+
+	got = getUnitState(manager, "idle.slice", 10, current)
+	want = map[string]interface{}{"active": "active", "sub": "active"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getUnitState(idle) = %v, want %v", got, want)
+	}
+
+	// A name without a type has no accounting interface to read
+	manager.units["odd."] = fakeSystemdUnit{SYSTEMD_UNIT + ".ActiveState": "inactive", SYSTEMD_UNIT + ".SubState": "dead"}
+	got = getUnitState(manager, "odd.", 10, current)
+	want = map[string]interface{}{"active": "inactive", "sub": "dead"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getUnitState(odd.) = %v, want %v", got, want)
+	}
+
+	got = getUnitState(manager, "missing.service", 10, current)
+	want = map[string]interface{}{"active": "unknown", "sub": "unknown"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getUnitState(missing) = %v, want %v", got, want)
+	}
+}
+
+func TestGetSystemdState(t *testing.T) {
+	setSystemdUnits(t, "web.service")
+	manager := &fakeSystemdManager{
+		failed: []systemdUnit{{Name: "a.service", ActiveState: "failed", SubState: "failed"}},
+		units: map[string]fakeSystemdUnit{"web.service": {
+			SYSTEMD_UNIT + ".ActiveState":              "active",
+			SYSTEMD_UNIT + ".SubState":                 "running",
+			SYSTEMD_INTERFACE + "Service.CPUUsageNSec": uint64(1e9),
+		}},
+	}
+
+	var got struct {
+		Failed []map[string]string          `json:"failed"`
+		Units  map[string]map[string]string `json:"units"`
+	}
+	if err := json.Unmarshal([]byte(getSystemdState(manager)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Failed) != 1 || got.Failed[0]["name"] != "a.service" {
+		t.Errorf("failed = %v, want a.service", got.Failed)
+	}
+	if got.Units["web.service"]["sub"] != "running" {
+		t.Errorf("units = %v, want web.service running", got.Units)
+	}
+	if SYSTEMD_FORMER["web.service"] != 1e9 {
+		t.Errorf("SYSTEMD_FORMER = %v, want web.service 1e9", SYSTEMD_FORMER)
+	}
+}
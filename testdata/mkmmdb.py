@@ -0,0 +1,62 @@
+# Generates the tiny mmdb fixtures used by geoip_test.go: python3 mkmmdb.py
+# Each database maps a single IPv4 /24 to one record.
+import os
+
+
+def ctrl(kind, size):
+    ext = b''
+    if size >= 29:
+        ext = bytes([size - 29])
+        size = 29
+    return bytes([(kind << 5) | size]) + ext
+
+
+def encode(value):
+    if isinstance(value, dict):
+        out = ctrl(7, len(value))
+        for key, item in value.items():
+            out += encode(key) + encode(item)
+        return out
+    if isinstance(value, str):
+        data = value.encode()
+        return ctrl(2, len(data)) + data
+    if isinstance(value, int):
+        data = value.to_bytes(4, 'big').lstrip(b'\0')
+        return ctrl(6, len(data)) + data
+    raise TypeError(value)
+
+
+def build(path, network, record):
+    prefix = int.from_bytes(bytes(map(int, network.split('.'))), 'big')
+    nodes = 24
+    tree = b''
+    for i in range(nodes):
+        bit = (prefix >> (31 - i)) & 1
+        # Records equal to the node count mean "not found", past it points into data
+        records = [nodes, nodes]
+        records[bit] = i + 1 if i < nodes - 1 else nodes + 16
+        tree += records[0].to_bytes(3, 'big') + records[1].to_bytes(3, 'big')
+    metadata = encode({
+        'node_count': nodes,
+        'record_size': 24,
+        'ip_version': 4,
+        'database_type': 'Test',
+        'binary_format_major_version': 2,
+        'binary_format_minor_version': 0,
+    })
+    with open(path, 'wb') as file:
+        file.write(tree + b'\0' * 16 + encode(record) + b'\xab\xcd\xefMaxMind.com' + metadata)
+
+
+here = os.path.dirname(os.path.abspath(__file__))
+build(os.path.join(here, 'country.mmdb'), '192.0.2.0', {
+    'country': {'iso_code': 'HK', 'names': {'en': 'Hong Kong'}},
+    'city': {'names': {'en': 'Central'}},
+})
+build(os.path.join(here, 'registered.mmdb'), '192.0.2.0', {
+    'registered_country': {'iso_code': 'JP', 'names': {'en': 'Japan'}},
+})
+build(os.path.join(here, 'asn.mmdb'), '192.0.2.0', {
+    'autonomous_system_number': 64500,
+    'autonomous_system_organization': 'Example Net',
+})
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// PROCESS_WATCH format: "label=type:value;label=type:value"
+// type is one of name, cmdline (regex), pidfile or user.
+type processWatch struct {
+	Label   string
+	Type    string
+	Value   string
+	pattern *regexp.Regexp
+}
+
+type watchState struct {
+	pid      int32
+	down     bool
+	restarts int
+}
+
+var WATCH_FORMER map[int32]float64 = nil
+var WATCH_FORMER_TIME time.Time
+var WATCH_STATE = map[string]*watchState{}
+
+func parseProcessWatch(config string) []processWatch {
+	watches := []processWatch{}
+
+	for _, entry := range strings.Split(config, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		label, rule, ok := strings.Cut(entry, "=")
+		if !ok {
+			logMessage(ERROR, fmt.Sprintf("Invalid process watch: %v", entry))
+			continue
+		}
+		watchType, value, ok := strings.Cut(rule, ":")
+		if !ok {
+			logMessage(ERROR, fmt.Sprintf("Invalid process watch: %v", entry))
+			continue
+		}
+
+		watch := processWatch{
+			Label: strings.TrimSpace(label),
+			Type:  strings.ToLower(strings.TrimSpace(watchType)),
+			Value: strings.TrimSpace(value),
+		}
+
+		switch watch.Type {
+		case "name", "pidfile", "user":
+		case "cmdline":
+			re, err := regexp.Compile(watch.Value)
+			if err != nil {
+				logMessage(ERROR, fmt.Sprintf("Invalid cmdline pattern for %v: %v", watch.Label, err))
+				continue
+			}
+			watch.pattern = re
+		default:
+			logMessage(ERROR, fmt.Sprintf("Unknown process watch type for %v: %v", watch.Label, watch.Type))
+			continue
+		}
+
+		watches = append(watches, watch)
+	}
+
+	return watches
+}
+
+func readPidFile(path string) int32 {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(file)), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(pid)
+}
+
+func matchProcessWatch(watch processWatch, p *process.Process, pidFilePid int32) bool {
+	switch watch.Type {
+	case "name":
+		name, err := p.Name()
+		return err == nil && name == watch.Value
+	case "cmdline":
+		cmdline, err := p.Cmdline()
+		return err == nil && watch.pattern.MatchString(cmdline)
+	case "pidfile":
+		return pidFilePid != 0 && p.Pid == pidFilePid
+	case "user":
+		user, err := p.Username()
+		return err == nil && user == watch.Value
+	}
+	return false
+}
+
+// Counts a restart when a watch comes back up after a report found it down, or
+// when its pidfile points to another process. Restarts between two reports
+// of the other watch types are not seen, the oldest of several matching
+// processes exiting is not a restart either.
+func updateWatchState(state *watchState, pid int32, pidFile bool) bool {
+	if pid == 0 {
+		state.down = state.pid != 0
+		return false
+	}
+
+	restarted := state.pid != 0 && (state.down || (pidFile && state.pid != pid))
+	if restarted {
+		state.restarts++
+	}
+	state.pid = pid
+	state.down = false
+	return restarted
+}
+
+func getProcessWatch() string {
+	// Get status of watched processes
+	if len(PROCESS_WATCHES) == 0 {
+		return "{}"
+	}
+
+	processes, err := process.Processes()
+	if err != nil {
+		logMessage(ERROR, fmt.Sprintf("Fail to list processes: %v", err))
+		return "{}"
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(WATCH_FORMER_TIME).Seconds()
+	current := make(map[int32]float64)
+	watches := make(map[string]interface{}, len(PROCESS_WATCHES))
+
+	for _, watch := range PROCESS_WATCHES {
+		var pidFilePid int32
+		if watch.Type == "pidfile" {
+			pidFilePid = readPidFile(watch.Value)
+		}
+
+		var (
+			mainPid    int32
+			createTime int64
+			count      int
+			cpuPercent float64
+			rss        uint64
+		)
+
+		for _, p := range processes {
+			if !matchProcessWatch(watch, p, pidFilePid) {
+				continue
+			}
+			count++
+
+			if times, err := p.Times(); err == nil {
+				total := times.User + times.System
+				current[p.Pid] = total
+				if former, ok := WATCH_FORMER[p.Pid]; ok && elapsed > 0 && total > former {
+					cpuPercent += (total - former) / elapsed * 100
+				}
+			}
+			if memory, err := p.MemoryInfo(); err == nil {
+				rss += memory.RSS
+			}
+
+			// The oldest matching process is treated as the main one
+			created, _ := p.CreateTime()
+			if mainPid == 0 || created < createTime {
+				mainPid = p.Pid
+				createTime = created
+			}
+		}
+
+		state, ok := WATCH_STATE[watch.Label]
+		if !ok {
+			state = &watchState{}
+			WATCH_STATE[watch.Label] = state
+		}
+		formerPid := state.pid
+		if updateWatchState(state, mainPid, watch.Type == "pidfile") {
+			logMessage(INFO, fmt.Sprintf("Process %v restarted: pid %v -> %v", watch.Label, formerPid, mainPid))
+		}
+
+		status := "down"
+		uptime := ""
+		if count > 0 {
+			status = "up"
+			if createTime > 0 && now.UnixMilli() > createTime {
+				uptime = formatUptime(uint64((now.UnixMilli() - createTime) / 1000))
+			}
+		} else {
+			logMessage(INFO, fmt.Sprintf("Process %v is down", watch.Label))
+		}
+
+		watches[watch.Label] = map[string]interface{}{
+			"status":   status,
+			"pid":      mainPid,
+			"count":    count,
+			"uptime":   uptime,
+			"restarts": state.restarts,
+			"cpu":      fmt.Sprintf("%.2f", cpuPercent),
+			"rss":      fmt.Sprintf("%.2f", float32(rss)/1024/1024),
+		}
+	}
+
+	WATCH_FORMER = current
+	WATCH_FORMER_TIME = now
+
+	data, _ := json.Marshal(watches)
+	logMessage(DEBUG, string(data))
+	return string(data)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseProcessWatch(t *testing.T) {
+	watches := parseProcessWatch(" nginx = name: nginx ;worker=CMDLINE:worker\\.py;db=pidfile:/run/db.pid;web=user:www-data;" +
+		"broken;nocolon=name;bad=cmdline:(;odd=port:80;;")
+
+	want := []processWatch{
+		{Label: "nginx", Type: "name", Value: "nginx"},
+		{Label: "worker", Type: "cmdline", Value: "worker\\.py"},
+		{Label: "db", Type: "pidfile", Value: "/run/db.pid"},
+		{Label: "web", Type: "user", Value: "www-data"},
+	}
+	if len(watches) != len(want) {
+		t.Fatalf("parseProcessWatch() = %v, want %v", watches, want)
+	}
+	for i, watch := range watches {
+		if watch.Label != want[i].Label || watch.Type != want[i].Type || watch.Value != want[i].Value {
+			t.Errorf("watch %v = %v, want %v", i, watch, want[i])
+		}
+		if (watch.pattern != nil) != (watch.Type == "cmdline") {
+			t.Errorf("watch %v pattern = %v", i, watch.pattern)
+		}
+	}
+	if !watches[1].pattern.MatchString("python3 /opt/worker.py") || watches[1].pattern.MatchString("python3 /opt/worker_py") {
+		t.Errorf("cmdline pattern %v should match worker.py only", watches[1].pattern)
+	}
+}
+
+func TestUpdateWatchState(t *testing.T) {
+	tests := []struct {
+		name     string
+		pidFile  bool
+		pids     []int32
+		restarts int
+	}{
+		{"steady", false, []int32{100, 100, 100}, 0},
+		// The oldest worker exiting hands over to the next one
+		{"handover", false, []int32{100, 101, 102}, 0},
+		{"down and up", false, []int32{100, 0, 0, 200, 0, 200}, 2},
+		{"down first", false, []int32{0, 0, 100}, 0},
+		{"pidfile", true, []int32{100, 200, 200, 0, 300}, 2},
+	}
+	for _, test := range tests {
+		state := &watchState{}
+		for _, pid := range test.pids {
+			updateWatchState(state, pid, test.pidFile)
+		}
+		if state.restarts != test.restarts {
+			t.Errorf("%v: restarts = %v, want %v", test.name, state.restarts, test.restarts)
+		}
+	}
+}
+
+func TestReadPidFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]int32{
+		"1234\n":     1234,
+		" 42 ":       42,
+		"":           0,
+		"nginx":      0,
+		"9999999999": 0,
+	}
+	for content, want := range tests {
+		path := filepath.Join(dir, "test.pid")
+		writeFixture(t, path, content)
+		if got := readPidFile(path); got != want {
+			t.Errorf("readPidFile(%q) = %v, want %v", content, got, want)
+		}
+	}
+	if got := readPidFile(filepath.Join(dir, "missing.pid")); got != 0 {
+		t.Errorf("readPidFile(missing) = %v, want 0", got)
+	}
+}
+
+func TestGetProcessWatch(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "test.pid")
+	writeFixture(t, pidFile, fmt.Sprintln(os.Getpid()))
+
+	oldWatches, oldState, oldFormer := PROCESS_WATCHES, WATCH_STATE, WATCH_FORMER
+	PROCESS_WATCHES = parseProcessWatch("self=pidfile:" + pidFile + ";none=name:no-such-process-name")
+	WATCH_STATE, WATCH_FORMER = map[string]*watchState{}, nil
+	t.Cleanup(func() { PROCESS_WATCHES, WATCH_STATE, WATCH_FORMER = oldWatches, oldState, oldFormer })
+
+	var watches map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(getProcessWatch()), &watches); err != nil {
+		t.Fatal(err)
+	}
+	self := watches["self"]
+	if self["status"] != "up" || self["pid"] != float64(os.Getpid()) || self["count"] != 1.0 || self["restarts"] != 0.0 {
+		t.Errorf("self = %v, want this process up", self)
+	}
+	none := watches["none"]
+	if none["status"] != "down" || none["pid"] != 0.0 || none["count"] != 0.0 {
+		t.Errorf("none = %v, want down", none)
+	}
+}